@@ -58,6 +58,15 @@ func loadConfigFromDisk(path string) (*Configuration, error) {
 func mergeConfigs(configs ...*Configuration) *Configuration {
 	newConfig := &Configuration{}
 	for _, config := range configs {
+		if !isZero(config.DaemonInterval) {
+			newConfig.DaemonInterval = config.DaemonInterval
+		}
+		if !isZero(config.DaemonJitter) {
+			newConfig.DaemonJitter = config.DaemonJitter
+		}
+		if !isZero(config.InstanceID) {
+			newConfig.InstanceID = config.InstanceID
+		}
 		if !isZero(config.DefinitionsURI) {
 			newConfig.DefinitionsURI = config.DefinitionsURI
 		}
@@ -73,9 +82,57 @@ func mergeConfigs(configs ...*Configuration) *Configuration {
 		if !isZero(config.PersistenceDirectory) {
 			newConfig.PersistenceDirectory = config.PersistenceDirectory
 		}
+		if !isZero(config.BindMountAllowlist) {
+			newConfig.BindMountAllowlist = config.BindMountAllowlist
+		}
+		if !isZero(config.StateDirectory) {
+			newConfig.StateDirectory = config.StateDirectory
+		}
+		if !isZero(config.ReportFile) {
+			newConfig.ReportFile = config.ReportFile
+		}
+		if !isZero(config.HistoryLimit) {
+			newConfig.HistoryLimit = config.HistoryLimit
+		}
+		if !isZero(config.MaxRemovals) {
+			newConfig.MaxRemovals = config.MaxRemovals
+		}
+		if !isZero(config.MaxRemovalPercent) {
+			newConfig.MaxRemovalPercent = config.MaxRemovalPercent
+		}
+		if !isZero(config.PullAttempts) {
+			newConfig.PullAttempts = config.PullAttempts
+		}
+		if !isZero(config.PullBackoff) {
+			newConfig.PullBackoff = config.PullBackoff
+		}
+		if !isZero(config.PullTimeout) {
+			newConfig.PullTimeout = config.PullTimeout
+		}
+		if !isZero(config.DeepVerify) {
+			newConfig.DeepVerify = config.DeepVerify
+		}
+		if !isZero(config.ImageGC) {
+			newConfig.ImageGC = config.ImageGC
+		}
+		if !isZero(config.ImageGCKeep) {
+			newConfig.ImageGCKeep = config.ImageGCKeep
+		}
+		if !isZero(config.RestartPolicy) {
+			newConfig.RestartPolicy = config.RestartPolicy
+		}
 		if !isZero(config.RegistryCredentials) {
 			newConfig.RegistryCredentials = config.RegistryCredentials
 		}
+		if !isZero(config.Ignore) {
+			newConfig.Ignore = config.Ignore
+		}
+		if !isZero(config.MaintenanceWindows) {
+			newConfig.MaintenanceWindows = config.MaintenanceWindows
+		}
+		if !isZero(config.FreezeFile) {
+			newConfig.FreezeFile = config.FreezeFile
+		}
 	}
 
 	return newConfig
@@ -6,11 +6,22 @@ import "reflect"
 func loadDefaultConfig() *Configuration {
 
 	config := &Configuration{
+		DaemonInterval:       "5m",
+		DaemonJitter:         "30s",
 		LogFormat:            "text",
 		LogLevel:             "info",
+		InstanceID:           "default",
 		DefinitionsURI:       "file:///etc/oneill/definitions",
 		DockerApiEndpoint:    "unix:///var/run/docker.sock",
 		PersistenceDirectory: "/var/lib/oneill/data",
+		StateDirectory:       "/var/lib/oneill/state",
+		MaxRemovalPercent:    50,
+		PullAttempts:         3,
+		PullBackoff:          "2s",
+		PullTimeout:          "10m",
+		ImageGCKeep:          3,
+		HistoryLimit:         50,
+		RestartPolicy:        "on-failure:10",
 	}
 
 	return config
@@ -1,15 +1,55 @@
 package config
 
 type Configuration struct {
+	DaemonInterval       string                         `yaml:"daemon_interval,omitempty"`
+	DaemonJitter         string                         `yaml:"daemon_jitter,omitempty"`
 	LogFormat            string                         `yaml:"log_format,omitempty"`
 	LogLevel             string                         `yaml:"log_level,omitempty"`
+	InstanceID           string                         `yaml:"instance_id,omitempty"`
 	DefinitionsURI       string                         `yaml:"definitions_uri,omitempty"`
 	DockerApiEndpoint    string                         `yaml:"docker_api_endpoint,omitempty"`
 	PersistenceDirectory string                         `yaml:"persistence_directory,omitempty"`
+	BindMountAllowlist   []string                       `yaml:"bind_mount_allowlist"`
+	StateDirectory       string                         `yaml:"state_directory,omitempty"`
+	ReportFile           string                         `yaml:"report_file,omitempty"`
+	HistoryLimit         int                            `yaml:"history_limit,omitempty"`
+	MaxRemovals          int                            `yaml:"max_removals,omitempty"`
+	MaxRemovalPercent    int                            `yaml:"max_removal_percent,omitempty"`
+	PullAttempts         int                            `yaml:"pull_attempts,omitempty"`
+	PullBackoff          string                         `yaml:"pull_backoff,omitempty"`
+	PullTimeout          string                         `yaml:"pull_timeout,omitempty"`
+	DeepVerify           bool                           `yaml:"deep_verify,omitempty"`
+	ImageGC              bool                           `yaml:"image_gc,omitempty"`
+	ImageGCKeep          int                            `yaml:"image_gc_keep,omitempty"`
+	RestartPolicy        string                         `yaml:"restart_policy,omitempty"`
 	RegistryCredentials  map[string]RegistryCredentials `yaml:"registry_credentials"`
+	Ignore               []IgnoreRule                   `yaml:"ignore"`
+	MaintenanceWindows   []MaintenanceWindow            `yaml:"maintenance_windows"`
+	FreezeFile           string                         `yaml:"freeze_file,omitempty"`
 }
 
 type RegistryCredentials struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 }
+
+// IgnoreRule matches containers that oneill should never touch. Exactly one
+// of the fields should be set: Name and Image are glob patterns matched
+// against the container's name and image, Label is a label selector in the
+// form `key` or `key=value`.
+type IgnoreRule struct {
+	Name  string `yaml:"name"`
+	Label string `yaml:"label"`
+	Image string `yaml:"image"`
+}
+
+// MaintenanceWindow is a period of the day, in local time, during which oneill
+// is allowed to change containers. Start and End are times of day in the form
+// `15:04`, a window that ends before it starts runs past midnight. Days limits
+// the window to the given days of the week (`mon` to `sun`), an empty list
+// means every day.
+type MaintenanceWindow struct {
+	Days  []string `yaml:"days"`
+	Start string   `yaml:"start"`
+	End   string   `yaml:"end"`
+}
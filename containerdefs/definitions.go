@@ -1,15 +1,23 @@
 package containerdefs
 
 import (
+	"fmt"
+	"path"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
 
+	"github.com/rehabstudio/oneill/config"
 	"github.com/rehabstudio/oneill/dockerclient"
+	"github.com/rehabstudio/oneill/schedule"
 )
 
 var (
 	rxContainerName = regexp.MustCompile(`^/?[a-zA-Z0-9_-]+$`)
+	rxDigest        = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
 )
 
 type ContainerDefinition struct {
@@ -21,23 +29,93 @@ type ContainerDefinition struct {
 	// definition.
 	ContainerName string `yaml:"container_name"`
 
+	// Type is either `service` (the default), a long running container that
+	// is restarted if it fails, or `job`, a container that is run to
+	// completion once per change to its spec or image.
+	Type string `yaml:"type"`
+
+	// Timeout is how long a job (or a run of a scheduled job) may run before
+	// it's killed and recorded as failed (default: 1h). Any duration
+	// understood by Go's time.ParseDuration is valid.
+	Timeout string `yaml:"timeout"`
+
+	// Schedule runs the definition as a scheduled job when oneill is running
+	// as a daemon, using standard five field cron syntax (e.g. `0 2 * * *`).
+	// Each run gets its own container, named `{container_name}-{timestamp}`.
+	Schedule string `yaml:"schedule"`
+
+	// AllowOverlap allows a scheduled job to start even if its previous run
+	// is still going (default: false, the new run is skipped).
+	AllowOverlap bool `yaml:"allow_overlap"`
+
+	// KeepFinished is the number of finished scheduled job containers kept
+	// for inspection (default: 3).
+	KeepFinished int `yaml:"keep_finished"`
+
 	// RepoTag controls the container that will be pulled and run for this
 	// container definition. This is in the same format as you would pass to
 	// `docker run`, e.g. `locahost:5000/myimage:latest`, `nginx`,
-	// `ubuntu:14.04`, `my.private.repo/myotherimage`
+	// `ubuntu:14.04`, `my.private.repo/myotherimage`. Images can be pinned to
+	// an immutable digest, e.g. `nginx@sha256:...` or `nginx:1.9@sha256:...`
 	RepoTag string `yaml:"repo_tag"`
 
+	// PullPolicy controls when the image is pulled from its registry:
+	// `always` (the default) on every run, `if_not_present` only when the
+	// image isn't available locally, `never`, or `interval:<duration>` (e.g.
+	// `interval:1h`) at most once per interval.
+	PullPolicy string `yaml:"pull_policy"`
+
 	// Env is a slice containing arbitrary environment variables that get
 	// passed to new containers at runtime. Variables set here will override
 	// environment variables set anywhere else (including those set by oneill
 	// itself), so use with caution.
 	Env dockerclient.Env `yaml:"env"`
 
+	// Command and Entrypoint override the image's CMD and ENTRYPOINT, either
+	// as a list of arguments or as a single string which is split into
+	// arguments like a shell would (without running a shell). As with
+	// `docker run --entrypoint`, the image's CMD isn't used when the
+	// entrypoint is overridden.
+	Command    dockerclient.Command `yaml:"command"`
+	Entrypoint dockerclient.Command `yaml:"entrypoint"`
+
+	// WorkingDir and User override the image's WORKDIR and USER, the user
+	// can be given in any form understood by `docker run --user`, e.g.
+	// `nobody` or `1000:1000`.
+	WorkingDir string `yaml:"working_dir"`
+	User       string `yaml:"user"`
+
+	// Memory and MemorySwap limit the memory (and memory plus swap) the
+	// container can use, in bytes or with a unit, e.g. `512m` or `1g`.
+	// MemorySwap can be `-1` for unlimited swap.
+	Memory     string `yaml:"memory"`
+	MemorySwap string `yaml:"memory_swap"`
+
+	// CPUShares sets the container's CPU weight relative to other
+	// containers, CPUs limits how many CPUs it can use (e.g. `1.5`) and
+	// CPUQuota does the same in microseconds of CPU time per 100ms. Cpuset
+	// pins the container to specific CPUs, e.g. `0-3` or `0,2`.
+	CPUShares int64  `yaml:"cpu_shares"`
+	CPUs      string `yaml:"cpus"`
+	CPUQuota  int64  `yaml:"cpu_quota"`
+	Cpuset    string `yaml:"cpuset"`
+
+	// PidsLimit limits the number of processes the container can run, `-1`
+	// for unlimited.
+	PidsLimit int64 `yaml:"pids_limit"`
+
 	// should persistence be enabled for this container? default off as we
 	// don't want to encourage people to use persistence (whilst acknowledging
 	// that it is necessary in some situations).
 	PersistenceEnabled bool `yaml:"persistence_enabled"`
 
+	// Volumes declares additional volumes mounted into the container: paths
+	// managed by oneill, docker named volumes or (if allowed by the
+	// configured bind_mount_allowlist) host bind mounts. A declared volume
+	// replaces the persistent volume for the same path if persistence is
+	// enabled.
+	Volumes []Volume `yaml:"volumes"`
+
 	// should the docker control socket be bind-mounted into this container?
 	// this is useful for service containers that need to be able to see or
 	// control what other containers are doing (nginx service, fluentd
@@ -50,70 +128,232 @@ type ContainerDefinition struct {
 	// are host port numbers and values are the internal port numbers that
 	// should be exposed.
 	PortMapping map[int]int `yaml:"port_mapping"`
+
+	// UpdateStrategy controls how an existing container is replaced when it
+	// no longer matches its definition. `recreate` (the default) removes the
+	// old container before starting the new one. `blue-green` starts the new
+	// container alongside the old one and only removes the old container once
+	// the new one is ready, falling back to `recreate` for definitions with a
+	// port mapping (two containers can't bind the same host port) or with
+	// writable persistent volumes (two containers mustn't write the same
+	// data at once).
+	UpdateStrategy string `yaml:"update_strategy"`
+
+	// ReadinessDelay is how long a new container must keep running before
+	// it is considered ready during a blue-green update (default: 10s). Any
+	// duration understood by Go's time.ParseDuration is valid.
+	ReadinessDelay string `yaml:"readiness_delay"`
+
+	// HealthCheck defines what "healthy" means for this container. Unhealthy
+	// containers are recreated, and blue-green updates wait for the new
+	// container to become healthy before replacing the old one.
+	HealthCheck *HealthCheck `yaml:"healthcheck"`
+
+	// RestartPolicy controls whether docker restarts the container when it
+	// exits: `no`, `always`, `unless-stopped`, `on-failure` or
+	// `on-failure:<max-restarts>` (default: the configured restart_policy).
+	// Jobs are never restarted.
+	RestartPolicy string `yaml:"restart_policy"`
+
+	// StopSignal is the signal sent to the container's main process when
+	// it's stopped, e.g. `SIGQUIT` (default: SIGTERM).
+	StopSignal string `yaml:"stop_signal"`
+
+	// StopTimeout is how long the container is given to exit after being
+	// sent its stop signal before it's killed (default: 10s).
+	StopTimeout string `yaml:"stop_timeout"`
+
+	// PreStop is an optional hook run against the container before it's
+	// sent its stop signal, e.g. to drain connections.
+	PreStop *PreStopHook `yaml:"pre_stop"`
+
+	// DependsOn lists the container definitions that must be started before
+	// this one. Containers are started in dependency order (independent
+	// containers are still started concurrently) and removed in reverse
+	// order.
+	DependsOn []Dependency `yaml:"depends_on"`
+
+	// Replicas runs several identical containers from a single definition.
+	// Each replica is named `{container_name}-{index}` (starting from 1) and
+	// is passed its index in the ONEILL_REPLICA_INDEX environment variable.
+	// When unset a single container named `{container_name}` is run.
+	Replicas int `yaml:"replicas"`
+
+	// DefinitionName is the container_name of the definition a replica was
+	// expanded from, and ReplicaIndex its index. Both are set by oneill when
+	// definitions are loaded.
+	DefinitionName string `yaml:"-"`
+	ReplicaIndex   int    `yaml:"-"`
 }
 
 // AlreadyRunning checks whether a container is already running that matches
 // *exactly* this container definition.
-func (cd *ContainerDefinition) AlreadyRunning(persistenceDir string) bool {
+func (cd *ContainerDefinition) AlreadyRunning(conf *config.Configuration) bool {
+	exists, reasons := cd.Drift(conf)
+	return exists && len(reasons) == 0
+}
+
+// Drift compares this container definition with any existing container of
+// the same name. The first return value reports whether such a container
+// exists at all, the second is a list of human readable reasons explaining
+// why the existing container doesn't match the definition (empty if it
+// matches *exactly*).
+func (cd *ContainerDefinition) Drift(conf *config.Configuration) (bool, []string) {
+	return cd.drift(conf, false)
+}
+
+// explainDrift behaves like Drift but is meant for read-only commands such as
+// `plan`. When the container's spec hash no longer matches it also compares
+// the container field by field to explain what changed (whether or not
+// deep_verify is enabled), the hash alone still decides whether the
+// container has drifted. Healthchecks are probed once rather than retried,
+// so a failing check doesn't block for `retries` intervals.
+func (cd *ContainerDefinition) explainDrift(conf *config.Configuration) (bool, []string) {
+	return cd.drift(conf, true)
+}
+
+// drift implements Drift and explainDrift.
+func (cd *ContainerDefinition) drift(conf *config.Configuration, explain bool) (bool, []string) {
+
+	// grab an APIContainer by name
+	c, err := dockerclient.GetContainerByName(cd.ContainerName)
+	if err != nil {
+		return false, []string{"no existing container"}
+	}
 
 	// check that an image with the given tag actually exists (container can't
 	// be running if the image isn't there)
 	availableImage, err := dockerclient.InspectImage(cd.RepoTag)
 	if err != nil {
-		return false
+		return true, []string{"image not present locally"}
 	}
 
-	// grab an APIContainer by name
-	c, err := dockerclient.GetContainerByName(cd.ContainerName)
+	runningContainer, err := dockerclient.InspectContainer(c.ID)
 	if err != nil {
-		return false
+		return true, []string{fmt.Sprintf("unable to inspect container: %s", err)}
 	}
 
-	// check that the container is actually running
-	runningContainer, err := dockerclient.InspectContainer(c.ID)
+	var reasons []string
+
+	// check that the container is labelled as owned by an oneill instance.
+	// Containers started before oneill labelled its containers will be
+	// recreated with the appropriate labels, adopting them from then on.
+	// Containers owned by other instances are never touched, callers check
+	// for them first with checkOwnership.
+	labels, err := dockerclient.ContainerLabels(c.ID)
 	if err != nil {
-		return false
+		reasons = append(reasons, fmt.Sprintf("unable to inspect container labels: %s", err))
+	} else if labels[LabelInstance] == "" {
+		reasons = append(reasons, "container not labelled as managed by any oneill instance")
 	}
+
+	// check that the container is actually running
 	if !runningContainer.State.Running {
-		return false
+		reasons = append(reasons, reasonNotRunning)
+	}
+
+	// check that the container is healthy (if a healthcheck is defined)
+	if cd.HealthCheck != nil && runningContainer.State.Running {
+		if status := cd.HealthCheck.Status(c.ID, !explain); status == "unhealthy" {
+			reasons = append(reasons, reasonUnhealthy)
+		}
 	}
 
 	// check that the image running is the latest that's available locally
 	if runningContainer.Image != availableImage.ID {
-		return false
+		reasons = append(reasons, fmt.Sprintf("image ID changed (%s -> %s)", shortID(runningContainer.Image), shortID(availableImage.ID)))
+	}
+
+	// check that the container was created from the current definition. The
+	// hash covers everything oneill passes to docker, so any change to the
+	// definition that affects the container is caught here.
+	specChanged := labels != nil && labels[LabelSpecHash] != cd.SpecHash(conf)
+	if specChanged {
+		reasons = append(reasons, "container definition changed")
 	}
 
+	// optionally compare the container's configuration field by field too,
+	// catching changes made outside of oneill (e.g. `docker update`)
+	if conf.DeepVerify || (explain && specChanged) {
+		reasons = append(reasons, cd.deepVerify(conf, runningContainer, availableImage)...)
+	}
+
+	return true, reasons
+}
+
+// deepVerify compares the configuration of a container field by field with
+// this definition, returning a human readable reason for each difference.
+// Docker normalises some values, so this can report differences that don't
+// really matter, which is why it's only used if deep_verify is enabled.
+func (cd *ContainerDefinition) deepVerify(conf *config.Configuration, runningContainer *docker.Container, availableImage *docker.Image) []string {
+
+	var reasons []string
+
 	// check that the running container's environment matches the one in
 	// the container definition
-	if !dockerclient.EnvsMatch(cd.Env, runningContainer.Config.Env, availableImage.Config.Env) {
-		return false
+	if keys := dockerclient.EnvDifferences(cd.Env, runningContainer.Config.Env, availableImage.Config.Env); len(keys) > 0 {
+		reasons = append(reasons, fmt.Sprintf("env differs (%s)", strings.Join(keys, ", ")))
+	}
+
+	// check that the running container's entrypoint, command, working
+	// directory and user match those in the container definition
+	if settings := dockerclient.ProcessDifferences(cd.resolvedOptions(conf), runningContainer.Config, availableImage.Config); len(settings) > 0 {
+		reasons = append(reasons, fmt.Sprintf("process differs (%s)", strings.Join(settings, ", ")))
+	}
+
+	// check that the running container's resource limits match those in
+	// the container definition
+	if running, err := dockerclient.ContainerResources(runningContainer.ID); err != nil {
+		reasons = append(reasons, fmt.Sprintf("unable to inspect container resources: %s", err))
+	} else if limits := dockerclient.ResourceDifferences(cd.resolvedOptions(conf).Resources, running); len(limits) > 0 {
+		reasons = append(reasons, fmt.Sprintf("resource limits differ (%s)", strings.Join(limits, ", ")))
+	}
+
+	// check that the running container's restart policy matches the one in
+	// the container definition
+	if !restartPoliciesMatch(cd.restartPolicy(conf), runningContainer.HostConfig.RestartPolicy) {
+		reasons = append(reasons, "restart policy differs")
 	}
 
 	// check that the running container has correctly bind-mounted the docker
 	// socket (if configured to do so)
 	if cd.DockerControlEnabled != dockerclient.DockerSocketMounted(runningContainer.HostConfig.Binds) {
-		return false
+		reasons = append(reasons, "docker socket bind differs")
 	}
 
 	// check that the running container has correctly bind-mounted the docker
 	// containers directory (if configured to do so)
 	if cd.DockerControlEnabled != dockerclient.DockerContainersDirMounted(runningContainer.HostConfig.Binds) {
-		return false
+		reasons = append(reasons, "docker containers directory bind differs")
 	}
 
 	// check that the running container's port mappings match those in the
 	// container definition
 	if !dockerclient.PortsMatch(cd.PortMapping, runningContainer.HostConfig.PortBindings) {
-		return false
+		reasons = append(reasons, "port mapping differs")
 	}
 
 	// check that the running container has correctly bind-mounted all volumes
 	// if persistence is enabled in the definition.
-	if cd.PersistenceEnabled && !dockerclient.AllVolumesMounted(cd.ContainerName, persistenceDir, runningContainer.Image, runningContainer.Volumes) {
-		return false
+	if cd.PersistenceEnabled {
+		missing, err := dockerclient.MissingVolumeBinds(cd.ContainerName, conf.PersistenceDirectory, runningContainer.Image, runningContainer.Volumes)
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("unable to inspect running image: %s", err))
+		}
+		for _, volume := range missing {
+			if !nameInList(volume, cd.volumeTargets()) {
+				reasons = append(reasons, fmt.Sprintf("volume bind missing (%s)", volume))
+			}
+		}
 	}
 
-	return true
+	// check that the running container has mounted each of the volumes
+	// declared in the definition
+	for _, bind := range dockerclient.MissingBinds(cd.volumeBinds(conf), runningContainer.HostConfig.Binds) {
+		reasons = append(reasons, fmt.Sprintf("volume bind missing (%s)", bind))
+	}
+
+	return reasons
 }
 
 // RemoveContainer removes a container with the same name as contained within
@@ -125,7 +365,7 @@ func (cd *ContainerDefinition) RemoveContainer() error {
 		return nil
 	}
 
-	err = dockerclient.RemoveContainer(container)
+	err = cd.removeContainer(container)
 	if err != nil {
 		return err
 	}
@@ -135,8 +375,71 @@ func (cd *ContainerDefinition) RemoveContainer() error {
 
 // StartContainer assembles the appropriate options structs and starts a new
 // container that matches the container definition.
-func (cd *ContainerDefinition) StartContainer(persistenceDir string) error {
-	return dockerclient.StartContainer(cd.ContainerName, cd.RepoTag, cd.Env, cd.DockerControlEnabled, cd.PersistenceEnabled, cd.PortMapping, persistenceDir)
+func (cd *ContainerDefinition) StartContainer(conf *config.Configuration) error {
+	_, err := cd.startContainerAs(conf, cd.ContainerName)
+	return err
+}
+
+// startContainerAs starts a new container that matches the container
+// definition but with the given name, returning the new container's ID.
+func (cd *ContainerDefinition) startContainerAs(conf *config.Configuration, name string) (string, error) {
+	return dockerclient.StartContainer(cd.containerOptions(conf, name))
+}
+
+// containerOptions assembles the options needed to start a container with
+// the given name from this definition. Persistent volumes are always mounted
+// from the definition's own persistence directory.
+func (cd *ContainerDefinition) containerOptions(conf *config.Configuration, name string) dockerclient.ContainerOptions {
+
+	opts := cd.resolvedOptions(conf)
+	opts.Name = name
+	opts.Labels = cd.Labels(conf)
+
+	// record exactly which image was deployed, a tag may point somewhere
+	// else entirely by the next time anyone looks
+	if digest, err := dockerclient.ResolveRepoDigest(cd.RepoTag); err == nil && digest != "" {
+		opts.Labels[LabelRepoDigest] = digest
+	}
+
+	return opts
+}
+
+// resolvedOptions returns this definition fully resolved into the options
+// passed to docker when creating a container, other than its name and
+// labels. The definition's spec hash is calculated from these options.
+func (cd *ContainerDefinition) resolvedOptions(conf *config.Configuration) dockerclient.ContainerOptions {
+
+	// resource limits are checked when the definition is validated
+	resources, _ := cd.resources()
+
+	return dockerclient.ContainerOptions{
+		RepoTag:              cd.RepoTag,
+		Env:                  cd.Env,
+		Entrypoint:           cd.Entrypoint,
+		Cmd:                  cd.Command,
+		WorkingDir:           cd.WorkingDir,
+		User:                 cd.User,
+		DockerControlEnabled: cd.DockerControlEnabled,
+		PersistenceEnabled:   cd.PersistenceEnabled,
+		PersistenceDir:       conf.PersistenceDirectory,
+		PersistenceName:      cd.ContainerName,
+		PortMapping:          cd.PortMapping,
+		Binds:                cd.volumeBinds(conf),
+		Healthcheck:          cd.nativeHealthcheck(),
+		RestartPolicy:        cd.restartPolicy(conf),
+		StopSignal:           cd.StopSignal,
+		StopTimeout:          cd.stopTimeout(),
+		Resources:            resources,
+	}
+}
+
+// nativeHealthcheck returns the docker native healthcheck configuration for
+// this definition, if any.
+func (cd *ContainerDefinition) nativeHealthcheck() *dockerclient.HealthConfig {
+	if cd.HealthCheck == nil {
+		return nil
+	}
+	return cd.HealthCheck.nativeConfig()
 }
 
 // Validate checks that a container definition is internally consistent and
@@ -158,6 +461,22 @@ func (cd *ContainerDefinition) Validate() bool {
 		return false
 	}
 
+	if _, _, digest := dockerclient.ParseImageReference(cd.RepoTag); digest != "" && !rxDigest.MatchString(digest) {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"repo_tag":       cd.RepoTag,
+		}).Warning("not a valid image digest in repo_tag")
+		return false
+	}
+
+	if _, _, err := parsePullPolicy(cd.PullPolicy); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"pull_policy":    cd.PullPolicy,
+		}).Warning("not a valid value for pull_policy")
+		return false
+	}
+
 	if !rxContainerName.MatchString(cd.ContainerName) {
 		logrus.WithFields(logrus.Fields{
 			"container_name": cd.ContainerName,
@@ -165,5 +484,162 @@ func (cd *ContainerDefinition) Validate() bool {
 		return false
 	}
 
+	if cd.Type != "" && cd.Type != TypeService && cd.Type != TypeJob {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"type":           cd.Type,
+		}).Warning("not a valid value for type")
+		return false
+	}
+
+	if cd.Schedule != "" {
+		if _, err := schedule.Parse(cd.Schedule); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"err":            err,
+			}).Warning("not a valid value for schedule")
+			return false
+		}
+		if cd.Type == TypeService || cd.Replicas > 0 || len(cd.PortMapping) > 0 {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+			}).Warning("scheduled jobs can't be services, or use replicas or port_mapping")
+			return false
+		}
+	}
+
+	if cd.Timeout != "" {
+		if d, err := time.ParseDuration(cd.Timeout); err != nil || d <= 0 {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"timeout":        cd.Timeout,
+			}).Warning("not a valid value for timeout")
+			return false
+		}
+	}
+
+	if cd.KeepFinished < 0 {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"keep_finished":  cd.KeepFinished,
+		}).Warning("not a valid value for keep_finished")
+		return false
+	}
+
+	if cd.Replicas < 0 {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"replicas":       cd.Replicas,
+		}).Warning("not a valid value for replicas")
+		return false
+	}
+
+	if cd.Replicas > 1 && len(cd.PortMapping) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+		}).Warning("port_mapping can't be used with more than one replica")
+		return false
+	}
+
+	if cd.UpdateStrategy != "" && cd.UpdateStrategy != UpdateRecreate && cd.UpdateStrategy != UpdateBlueGreen {
+		logrus.WithFields(logrus.Fields{
+			"container_name":  cd.ContainerName,
+			"update_strategy": cd.UpdateStrategy,
+		}).Warning("not a valid value for update_strategy")
+		return false
+	}
+
+	if cd.HealthCheck != nil {
+		if err := cd.HealthCheck.validate(); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"err":            err,
+			}).Warning("not a valid healthcheck")
+			return false
+		}
+	}
+
+	if cd.WorkingDir != "" && !path.IsAbs(cd.WorkingDir) {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"working_dir":    cd.WorkingDir,
+		}).Warning("working_dir must be an absolute path")
+		return false
+	}
+
+	if _, err := cd.resources(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Warning("not a valid resource limit")
+		return false
+	}
+
+	if cd.RestartPolicy != "" {
+		_, err := ParseRestartPolicy(cd.RestartPolicy)
+		if err != nil || ((cd.IsJob() || cd.IsScheduled()) && cd.RestartPolicy != RestartNo) {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"restart_policy": cd.RestartPolicy,
+			}).Warning("not a valid value for restart_policy (jobs can only use `no`)")
+			return false
+		}
+	}
+
+	if err := cd.validateVolumes(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Warning("not a valid volume")
+		return false
+	}
+
+	if cd.StopSignal != "" && !rxStopSignal.MatchString(cd.StopSignal) {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"stop_signal":    cd.StopSignal,
+		}).Warning("not a valid value for stop_signal")
+		return false
+	}
+
+	if cd.StopTimeout != "" {
+		if d, err := time.ParseDuration(cd.StopTimeout); err != nil || d < 0 {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"stop_timeout":   cd.StopTimeout,
+			}).Warning("not a valid value for stop_timeout")
+			return false
+		}
+	}
+
+	if cd.PreStop != nil {
+		if err := cd.PreStop.validate(); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"err":            err,
+			}).Warning("not a valid pre_stop hook")
+			return false
+		}
+	}
+
+	if cd.ReadinessDelay != "" {
+		if _, err := time.ParseDuration(cd.ReadinessDelay); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"container_name":  cd.ContainerName,
+				"readiness_delay": cd.ReadinessDelay,
+			}).Warning("not a valid value for readiness_delay")
+			return false
+		}
+	}
+
 	return true
 }
+
+// shortID truncates a docker ID to the 12 character form shown by the docker
+// cli, making log and plan output a little easier to read.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,198 @@
+package containerdefs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// conditions that can be attached to a dependency
+const (
+	ConditionStarted = "started"
+	ConditionHealthy = "healthy"
+)
+
+// Dependency names another container definition that must be started before
+// the definition declaring it. In YAML a dependency can be written either as
+// a plain container name or as a map with `name` and `condition` keys.
+type Dependency struct {
+	// Name is the container_name of the definition depended upon
+	Name string `yaml:"name"`
+
+	// Condition controls what "started" means for the dependency. `started`
+	// (the default) only waits for the dependency to be processed, `healthy`
+	// also waits for it to pass its healthcheck.
+	Condition string `yaml:"condition"`
+}
+
+// UnmarshalYAML allows a dependency to be given as a plain string as well as
+// a full map.
+func (d *Dependency) UnmarshalYAML(unmarshal func(v interface{}) error) error {
+
+	var name string
+	if err := unmarshal(&name); err == nil {
+		d.Name = name
+		return nil
+	}
+
+	// unmarshal into an alias type to avoid recursing back into this method
+	type dependency Dependency
+	var dep dependency
+	if err := unmarshal(&dep); err != nil {
+		return err
+	}
+	*d = Dependency(dep)
+
+	return nil
+}
+
+// validateDependencies checks that every dependency refers to a known
+// container definition, that all conditions are valid and that the
+// dependencies don't contain any cycles.
+func validateDependencies(cdefs []*ContainerDefinition) error {
+
+	byName := make(map[string]*ContainerDefinition)
+	for _, cd := range cdefs {
+		byName[cd.ContainerName] = cd
+	}
+
+	for _, cd := range cdefs {
+		for _, dep := range cd.DependsOn {
+			depDef, ok := byName[dep.Name]
+			if !ok {
+				return fmt.Errorf("%s depends on unknown container definition: %s", cd.ContainerName, dep.Name)
+			}
+			if depDef.IsScheduled() {
+				return fmt.Errorf("%s can't depend on scheduled job: %s", cd.ContainerName, dep.Name)
+			}
+			if dep.Condition != "" && dep.Condition != ConditionStarted && dep.Condition != ConditionHealthy {
+				return fmt.Errorf("%s has an invalid condition for dependency %s: %s", cd.ContainerName, dep.Name, dep.Condition)
+			}
+		}
+	}
+
+	// depth first search, tracking the current path so we can report it
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(cd *ContainerDefinition) error
+	visit = func(cd *ContainerDefinition) error {
+		switch state[cd.ContainerName] {
+		case visiting:
+			return fmt.Errorf("Container definitions contain a dependency cycle: %s -> %s", strings.Join(path, " -> "), cd.ContainerName)
+		case visited:
+			return nil
+		}
+		state[cd.ContainerName] = visiting
+		path = append(path, cd.ContainerName)
+		for _, dep := range cd.DependsOn {
+			if err := visit(byName[dep.Name]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[cd.ContainerName] = visited
+		return nil
+	}
+
+	for _, cd := range cdefs {
+		if err := visit(cd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dependencyNames returns the sorted names of all definitions this definition
+// depends on.
+func (cd *ContainerDefinition) dependencyNames() []string {
+	var names []string
+	for _, dep := range cd.DependsOn {
+		names = append(names, dep.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// waitForDependency blocks until the given dependency satisfies its
+// condition. Dependencies without a healthcheck are considered healthy as
+// soon as they've started.
+func waitForDependency(dep Dependency, cd *ContainerDefinition) error {
+
+	if dep.Condition != ConditionHealthy || cd.HealthCheck == nil {
+		return nil
+	}
+
+	c, err := dockerclient.GetContainerByName(cd.ContainerName)
+	if err != nil {
+		return err
+	}
+
+	return cd.HealthCheck.WaitHealthy(c.ID)
+}
+
+// sortForRemoval orders containers so that dependent containers are removed
+// before the containers they depend on, using the dependency label applied
+// when each container was started.
+func sortForRemoval(containers []docker.APIContainers) []docker.APIContainers {
+
+	// map each container name to the names of its dependencies
+	dependencies := make(map[string][]string)
+	byName := make(map[string]docker.APIContainers)
+	var names []string
+	for _, c := range containers {
+		name := strings.TrimPrefix(c.Names[0], "/")
+		byName[name] = c
+		names = append(names, name)
+		labels, err := dockerclient.ContainerLabels(c.ID)
+		if err == nil && labels[LabelDependsOn] != "" {
+			dependencies[name] = strings.Split(labels[LabelDependsOn], ",")
+		}
+	}
+
+	// count how many of the containers being removed depend on each one
+	dependents := make(map[string]int)
+	for _, deps := range dependencies {
+		for _, dep := range deps {
+			dependents[dep] = dependents[dep] + 1
+		}
+	}
+
+	// repeatedly remove containers that nothing else depends on, any
+	// containers left over (only possible with a cycle) are removed last
+	var sorted []docker.APIContainers
+	removed := make(map[string]bool)
+	for len(sorted) < len(names) {
+		progress := false
+		for _, name := range names {
+			if removed[name] || dependents[name] > 0 {
+				continue
+			}
+			removed[name] = true
+			progress = true
+			sorted = append(sorted, byName[name])
+			for _, dep := range dependencies[name] {
+				dependents[dep] = dependents[dep] - 1
+			}
+		}
+		if !progress {
+			for _, name := range names {
+				if !removed[name] {
+					removed[name] = true
+					sorted = append(sorted, byName[name])
+				}
+			}
+		}
+	}
+
+	return sorted
+}
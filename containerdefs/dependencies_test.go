@@ -0,0 +1,102 @@
+package containerdefs
+
+import (
+	"strings"
+	"testing"
+)
+
+// definitions builds a list of container definitions from a map of container
+// names to the names they depend on.
+func definitions(deps map[string][]string) []*ContainerDefinition {
+
+	var cdefs []*ContainerDefinition
+	for name, names := range deps {
+		cd := &ContainerDefinition{ContainerName: name}
+		for _, dep := range names {
+			cd.DependsOn = append(cd.DependsOn, Dependency{Name: dep})
+		}
+		cdefs = append(cdefs, cd)
+	}
+
+	return cdefs
+}
+
+func TestValidateDependencies(t *testing.T) {
+
+	tests := []map[string][]string{
+		{},
+		{"web": nil},
+		{"web": {"db"}, "db": nil},
+		{"web": {"db", "cache"}, "db": nil, "cache": nil},
+		{"web": {"api"}, "api": {"db"}, "db": nil},
+		{"web": {"db"}, "worker": {"db"}, "db": nil},
+		{"web": {"api", "db"}, "api": {"db"}, "db": nil},
+	}
+
+	for _, deps := range tests {
+		if err := validateDependencies(definitions(deps)); err != nil {
+			t.Errorf("Unexpected error validating %v: %s", deps, err)
+		}
+	}
+}
+
+func TestValidateDependenciesErrors(t *testing.T) {
+
+	tests := []struct {
+		deps map[string][]string
+		err  string
+	}{
+		{map[string][]string{"web": {"db"}}, "unknown container definition: db"},
+		{map[string][]string{"web": {"db"}, "api": nil}, "unknown container definition: db"},
+		{map[string][]string{"web": {"web"}}, "dependency cycle: web -> web"},
+		{map[string][]string{"web": {"db"}, "db": {"web"}}, "dependency cycle"},
+		{map[string][]string{"web": {"api"}, "api": {"db"}, "db": {"web"}}, "dependency cycle"},
+		{map[string][]string{"web": nil, "api": {"db"}, "db": {"cache"}, "cache": {"api"}}, "dependency cycle"},
+	}
+
+	for _, test := range tests {
+		err := validateDependencies(definitions(test.deps))
+		if err == nil {
+			t.Errorf("Expected error validating %v", test.deps)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Expected error validating %v to contain %q, got %q", test.deps, test.err, err)
+		}
+	}
+}
+
+func TestValidateDependenciesConditions(t *testing.T) {
+
+	tests := []struct {
+		condition string
+		valid     bool
+	}{
+		{"", true},
+		{ConditionStarted, true},
+		{ConditionHealthy, true},
+		{"running", false},
+		{"Healthy", false},
+	}
+
+	for _, test := range tests {
+		cdefs := []*ContainerDefinition{
+			{ContainerName: "web", DependsOn: []Dependency{{Name: "db", Condition: test.condition}}},
+			{ContainerName: "db"},
+		}
+		if err := validateDependencies(cdefs); (err == nil) != test.valid {
+			t.Errorf("Expected condition %q to be valid: %t, got error %v", test.condition, test.valid, err)
+		}
+	}
+}
+
+func TestValidateDependenciesScheduled(t *testing.T) {
+
+	cdefs := []*ContainerDefinition{
+		{ContainerName: "web", DependsOn: []Dependency{{Name: "backup"}}},
+		{ContainerName: "backup", Schedule: "@daily"},
+	}
+	if err := validateDependencies(cdefs); err == nil {
+		t.Errorf("Expected error depending on a scheduled job")
+	}
+}
@@ -0,0 +1,31 @@
+package containerdefs
+
+import (
+	"fmt"
+
+	"github.com/rehabstudio/oneill/config"
+)
+
+// checkRemovalLimits guards against removing most or all of the containers on
+// a host because the definitions source returned an empty or truncated list.
+// An error is returned if removing the given number of containers (out of
+// the total number managed by this instance) would exceed either of the
+// configured limits.
+func checkRemovalLimits(conf *config.Configuration, removing, managed int) error {
+
+	if removing == 0 {
+		return nil
+	}
+
+	if conf.MaxRemovals > 0 && removing > conf.MaxRemovals {
+		return fmt.Errorf("Refusing to remove %d of %d managed containers, more than max_removals (%d). Use -force to override",
+			removing, managed, conf.MaxRemovals)
+	}
+
+	if conf.MaxRemovalPercent > 0 && managed > 0 && removing*100 > managed*conf.MaxRemovalPercent {
+		return fmt.Errorf("Refusing to remove %d of %d managed containers, more than max_removal_percent (%d%%). Use -force to override",
+			removing, managed, conf.MaxRemovalPercent)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package containerdefs
+
+import (
+	"testing"
+
+	"github.com/rehabstudio/oneill/config"
+)
+
+func TestCheckRemovalLimits(t *testing.T) {
+
+	tests := []struct {
+		maxRemovals, maxPercent int
+		removing, managed       int
+		allowed                 bool
+	}{
+		// nothing to remove is always allowed
+		{1, 10, 0, 0, true},
+		{1, 10, 0, 100, true},
+
+		// limits of zero are disabled
+		{0, 0, 100, 100, true},
+
+		// max_removals
+		{5, 0, 5, 100, true},
+		{5, 0, 6, 100, false},
+		{1, 0, 2, 2, false},
+
+		// max_removal_percent
+		{0, 50, 5, 10, true},
+		{0, 50, 6, 10, false},
+		{0, 50, 1, 3, true},
+		{0, 50, 2, 3, false},
+		{0, 100, 10, 10, true},
+		{0, 50, 1, 0, true},
+
+		// both limits apply
+		{5, 50, 5, 20, true},
+		{5, 50, 6, 20, false},
+		{5, 50, 3, 5, false},
+	}
+
+	for _, test := range tests {
+		conf := &config.Configuration{MaxRemovals: test.maxRemovals, MaxRemovalPercent: test.maxPercent}
+		err := checkRemovalLimits(conf, test.removing, test.managed)
+		if (err == nil) != test.allowed {
+			t.Errorf("Expected removing %d of %d containers (max_removals %d, max_removal_percent %d) to be allowed: %t, got error %v",
+				test.removing, test.managed, test.maxRemovals, test.maxPercent, test.allowed, err)
+		}
+	}
+}
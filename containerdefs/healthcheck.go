@@ -0,0 +1,249 @@
+package containerdefs
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// default timings used when a healthcheck doesn't specify its own
+const (
+	defaultHealthInterval = 10 * time.Second
+	defaultHealthTimeout  = 5 * time.Second
+	defaultHealthRetries  = 3
+)
+
+// HTTPCheck probes a container by making an HTTP GET request to the given
+// port and path on the container's IP address. Any 2xx or 3xx response is
+// considered healthy.
+type HTTPCheck struct {
+	Port int    `yaml:"port"`
+	Path string `yaml:"path"`
+}
+
+// TCPCheck probes a container by opening a TCP connection to the given port
+// on the container's IP address.
+type TCPCheck struct {
+	Port int `yaml:"port"`
+}
+
+// HealthCheck defines what "healthy" means for a container. Exactly one of
+// HTTP, TCP or Exec should be set. Exec checks are also configured as the
+// container's native docker HEALTHCHECK, HTTP and TCP checks can only be
+// probed by oneill itself since the image might not contain the tools needed
+// to perform them from inside the container.
+type HealthCheck struct {
+	HTTP *HTTPCheck `yaml:"http"`
+	TCP  *TCPCheck  `yaml:"tcp"`
+
+	// Exec is a command run inside the container, an exit code of 0 is
+	// considered healthy
+	Exec []string `yaml:"exec"`
+
+	// Interval is the time between probes (default: 10s)
+	Interval string `yaml:"interval"`
+
+	// Timeout is the maximum time a single probe may take (default: 5s)
+	Timeout string `yaml:"timeout"`
+
+	// Retries is the number of consecutive failed probes required before a
+	// container is considered unhealthy (default: 3)
+	Retries int `yaml:"retries"`
+
+	// StartPeriod gives a container time to initialise, failed probes during
+	// this period aren't counted (default: 0s)
+	StartPeriod string `yaml:"start_period"`
+}
+
+// parseDurationOr parses a duration string, returning def if the string is
+// empty or invalid.
+func parseDurationOr(s string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d
+	}
+	return def
+}
+
+func (hc *HealthCheck) interval() time.Duration {
+	return parseDurationOr(hc.Interval, defaultHealthInterval)
+}
+
+func (hc *HealthCheck) timeout() time.Duration {
+	return parseDurationOr(hc.Timeout, defaultHealthTimeout)
+}
+
+func (hc *HealthCheck) startPeriod() time.Duration {
+	return parseDurationOr(hc.StartPeriod, 0)
+}
+
+func (hc *HealthCheck) retries() int {
+	if hc.Retries > 0 {
+		return hc.Retries
+	}
+	return defaultHealthRetries
+}
+
+// validate checks that the healthcheck is internally consistent.
+func (hc *HealthCheck) validate() error {
+
+	var checks int
+	if hc.HTTP != nil {
+		checks = checks + 1
+		if hc.HTTP.Port <= 0 || hc.HTTP.Port > 65535 {
+			return fmt.Errorf("not a valid http port: %d", hc.HTTP.Port)
+		}
+	}
+	if hc.TCP != nil {
+		checks = checks + 1
+		if hc.TCP.Port <= 0 || hc.TCP.Port > 65535 {
+			return fmt.Errorf("not a valid tcp port: %d", hc.TCP.Port)
+		}
+	}
+	if len(hc.Exec) > 0 {
+		checks = checks + 1
+	}
+	if checks != 1 {
+		return fmt.Errorf("exactly one of http, tcp or exec must be set")
+	}
+
+	for name, value := range map[string]string{"interval": hc.Interval, "timeout": hc.Timeout, "start_period": hc.StartPeriod} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("not a valid value for %s: %s", name, value)
+		}
+	}
+
+	if hc.Retries < 0 {
+		return fmt.Errorf("not a valid value for retries: %d", hc.Retries)
+	}
+
+	return nil
+}
+
+// nativeConfig returns the docker native healthcheck configuration for this
+// healthcheck, or nil if it can't be expressed as one.
+func (hc *HealthCheck) nativeConfig() *dockerclient.HealthConfig {
+
+	if len(hc.Exec) == 0 {
+		return nil
+	}
+
+	return &dockerclient.HealthConfig{
+		Test:        append([]string{"CMD"}, hc.Exec...),
+		Interval:    hc.interval(),
+		Timeout:     hc.timeout(),
+		StartPeriod: hc.startPeriod(),
+		Retries:     hc.retries(),
+	}
+}
+
+// Probe performs a single health probe against the given container.
+func (hc *HealthCheck) Probe(containerID string) error {
+
+	container, err := dockerclient.InspectContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if !container.State.Running {
+		return fmt.Errorf("container not running")
+	}
+	ip := container.NetworkSettings.IPAddress
+
+	switch {
+	case hc.HTTP != nil:
+		httpClient := &http.Client{Timeout: hc.timeout()}
+		url := fmt.Sprintf("http://%s%s", net.JoinHostPort(ip, strconv.Itoa(hc.HTTP.Port)), hc.HTTP.Path)
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("http healthcheck returned status %d", resp.StatusCode)
+		}
+
+	case hc.TCP != nil:
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(hc.TCP.Port)), hc.timeout())
+		if err != nil {
+			return err
+		}
+		conn.Close()
+
+	default:
+		exitCode, err := dockerclient.ExecCommand(containerID, hc.Exec, hc.timeout())
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("exec healthcheck exited with status %d", exitCode)
+		}
+	}
+
+	return nil
+}
+
+// Status returns the current health of a container: `healthy`, `unhealthy`
+// or `starting` (still within its start period). Docker's native health
+// status is used where available, otherwise oneill probes the container
+// itself. If retry is set failed probes are retried (waiting `interval`
+// between each) before giving up, otherwise a single probe decides, so that
+// read-only commands like `status` and `plan` don't block.
+func (hc *HealthCheck) Status(containerID string, retry bool) string {
+
+	if status, err := dockerclient.ContainerHealth(containerID); err == nil && status != "" {
+		return status
+	}
+
+	container, err := dockerclient.InspectContainer(containerID)
+	if err != nil {
+		return "unhealthy"
+	}
+	inStartPeriod := time.Since(container.State.StartedAt) < hc.startPeriod()
+
+	attempts := 1
+	if retry {
+		attempts = hc.retries()
+	}
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(hc.interval())
+		}
+		if hc.Probe(containerID) == nil {
+			return "healthy"
+		}
+	}
+
+	if inStartPeriod {
+		return "starting"
+	}
+	return "unhealthy"
+}
+
+// WaitHealthy blocks until the given container passes its healthcheck,
+// returning an error once the container fails `retries` consecutive probes
+// after its start period has elapsed.
+func (hc *HealthCheck) WaitHealthy(containerID string) error {
+
+	startPeriodEnds := time.Now().Add(hc.startPeriod())
+
+	var failures int
+	for {
+		err := hc.Probe(containerID)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(startPeriodEnds) {
+			failures = failures + 1
+			if failures >= hc.retries() {
+				return fmt.Errorf("healthcheck failed: %s", err)
+			}
+		}
+		time.Sleep(hc.interval())
+	}
+}
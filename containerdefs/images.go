@@ -0,0 +1,203 @@
+package containerdefs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// pulledImage records an image oneill has pulled for a definition, and when
+// it was last used.
+type pulledImage struct {
+	ID       string    `json:"id"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// byLastUsed sorts pulled images, most recently used first
+type byLastUsed []*pulledImage
+
+func (b byLastUsed) Len() int           { return len(b) }
+func (b byLastUsed) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byLastUsed) Less(i, j int) bool { return b[i].LastUsed.After(b[j].LastUsed) }
+
+// pulledImages maps repositories to the images pulled from them. It's stored
+// in oneill's state directory so that only images oneill pulled itself are
+// ever garbage collected.
+type pulledImages map[string][]*pulledImage
+
+// imageStateLock serialises access to the pulled images state file, images
+// are pulled concurrently for each definition
+var imageStateLock sync.Mutex
+
+func imageStatePath(conf *config.Configuration) string {
+	return path.Join(conf.StateDirectory, "images.json")
+}
+
+// loadPulledImages reads the pulled images state file, returning an empty
+// set if it doesn't exist yet.
+func loadPulledImages(conf *config.Configuration) (pulledImages, error) {
+
+	images := make(pulledImages)
+	data, err := ioutil.ReadFile(imageStatePath(conf))
+	if os.IsNotExist(err) {
+		return images, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return images, json.Unmarshal(data, &images)
+}
+
+// savePulledImages writes the pulled images state file.
+func savePulledImages(conf *config.Configuration, images pulledImages) error {
+
+	if err := os.MkdirAll(conf.StateDirectory, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(images, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(imageStatePath(conf), data, 0644)
+}
+
+// recordImage marks the local image for this definition's repo_tag as used
+// just now. Only images oneill has pulled itself are candidates for removal,
+// so an image is only added to the record if pulled is set, otherwise only
+// images already recorded are updated. Images that were built locally or
+// loaded by hand are never recorded.
+func (cd *ContainerDefinition) recordImage(conf *config.Configuration, pulled bool) error {
+
+	image, err := dockerclient.InspectImage(cd.RepoTag)
+	if err != nil {
+		return err
+	}
+	repository, _, _ := dockerclient.ParseImageReference(cd.RepoTag)
+
+	imageStateLock.Lock()
+	defer imageStateLock.Unlock()
+
+	images, err := loadPulledImages(conf)
+	if err != nil {
+		return err
+	}
+
+	var found bool
+	for _, pulled := range images[repository] {
+		if pulled.ID == image.ID {
+			pulled.LastUsed = time.Now()
+			found = true
+		}
+	}
+	if !found && pulled {
+		images[repository] = append(images[repository], &pulledImage{ID: image.ID, LastUsed: time.Now()})
+	}
+
+	return savePulledImages(conf, images)
+}
+
+// imagesInUse returns the IDs of every image used by a container on the host
+// (managed by oneill or not) or by one of the given definitions.
+func imagesInUse(cdefs []*ContainerDefinition) (map[string]bool, error) {
+
+	inUse := make(map[string]bool)
+
+	containers, err := dockerclient.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		container, err := dockerclient.InspectContainer(c.ID)
+		if err != nil {
+			return nil, err
+		}
+		inUse[container.Image] = true
+	}
+
+	for _, cd := range cdefs {
+		if image, err := dockerclient.InspectImage(cd.RepoTag); err == nil {
+			inUse[image.ID] = true
+		}
+	}
+
+	return inUse, nil
+}
+
+// RemoveSupersededImages removes images that oneill pulled for a definition
+// but which are no longer used. The most recently used images for each
+// repository are kept (image_gc_keep) so that it's possible to roll back,
+// and images used by any container on the host, managed or not, are never
+// removed.
+func RemoveSupersededImages(conf *config.Configuration, cdefs []*ContainerDefinition) error {
+
+	// always keep at least the most recently used image
+	keep := conf.ImageGCKeep
+	if keep < 1 {
+		keep = 1
+	}
+
+	inUse, err := imagesInUse(cdefs)
+	if err != nil {
+		return err
+	}
+
+	imageStateLock.Lock()
+	defer imageStateLock.Unlock()
+
+	images, err := loadPulledImages(conf)
+	if err != nil {
+		return err
+	}
+
+	for repository, pulled := range images {
+
+		// most recently used first
+		sort.Sort(byLastUsed(pulled))
+
+		var remaining []*pulledImage
+		for i, image := range pulled {
+			if i < keep || inUse[image.ID] {
+				remaining = append(remaining, image)
+				continue
+			}
+
+			// forget about images that have already been removed by hand
+			if _, err := dockerclient.InspectImage(image.ID); err != nil {
+				continue
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"repository": repository,
+				"image_id":   shortID(image.ID),
+			}).Info("Removing superseded docker image")
+			if err := dockerclient.RemoveImage(image.ID); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"repository": repository,
+					"image_id":   shortID(image.ID),
+					"err":        err,
+				}).Warning("Unable to remove superseded docker image")
+				remaining = append(remaining, image)
+			}
+		}
+
+		if len(remaining) == 0 {
+			delete(images, repository)
+		} else {
+			images[repository] = remaining
+		}
+	}
+
+	return savePulledImages(conf, images)
+}
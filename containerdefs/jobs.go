@@ -0,0 +1,166 @@
+package containerdefs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// types of container definition
+const (
+	TypeService = "service"
+	TypeJob     = "job"
+)
+
+// ActionRun is recorded when a job container is run.
+const ActionRun Action = "run"
+
+// defaultJobTimeout is how long a job may run if its definition doesn't set
+// a timeout.
+const defaultJobTimeout = time.Hour
+
+// IsJob reports whether this definition is a run-to-completion job rather
+// than a long running service.
+func (cd *ContainerDefinition) IsJob() bool {
+	return cd.Type == TypeJob
+}
+
+// jobTimeout returns how long a job may run before it's killed.
+func (cd *ContainerDefinition) jobTimeout() time.Duration {
+	if d, err := time.ParseDuration(cd.Timeout); err == nil {
+		return d
+	}
+	return defaultJobTimeout
+}
+
+// jobStatePath returns the path of the file used to remember the last
+// successful run of the named job.
+func jobStatePath(conf *config.Configuration, name string) string {
+	return path.Join(conf.StateDirectory, "jobs", name)
+}
+
+// jobKey identifies a single version of a job, a job only needs to be run
+// again when its spec or the image it runs changes.
+func (cd *ContainerDefinition) jobKey(conf *config.Configuration) (string, error) {
+
+	image, err := dockerclient.InspectImage(cd.RepoTag)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s %s", cd.SpecHash(conf), image.ID), nil
+}
+
+// jobPending checks whether a job needs to be run, returning the reason if
+// so.
+func (cd *ContainerDefinition) jobPending(conf *config.Configuration) (bool, string) {
+
+	key, err := cd.jobKey(conf)
+	if err != nil {
+		return true, "image not present locally"
+	}
+
+	data, err := ioutil.ReadFile(jobStatePath(conf, cd.ContainerName))
+	if err != nil {
+		return true, "job has never completed successfully"
+	}
+	if strings.TrimSpace(string(data)) != key {
+		return true, "spec or image changed since last successful run"
+	}
+
+	return false, ""
+}
+
+// recordJobSuccess remembers that the current version of a job has completed
+// successfully so it isn't run again.
+func (cd *ContainerDefinition) recordJobSuccess(conf *config.Configuration) error {
+
+	key, err := cd.jobKey(conf)
+	if err != nil {
+		return err
+	}
+
+	statePath := jobStatePath(conf, cd.ContainerName)
+	if err := os.MkdirAll(path.Dir(statePath), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(statePath, []byte(key+"\n"), 0644)
+}
+
+// runJob starts a job container, waiting for it to complete and checking
+// its exit code. The finished container is left in place so its logs can be
+// inspected, it will be removed the next time the job is run.
+func (cd *ContainerDefinition) runJob(conf *config.Configuration) error {
+
+	// remove the container left behind by the previous run (if any)
+	if err := cd.RemoveContainer(); err != nil {
+		return err
+	}
+
+	id, err := cd.startContainerAs(conf, cd.ContainerName)
+	if err != nil {
+		return err
+	}
+
+	exitCode, err := dockerclient.WaitContainer(id, cd.jobTimeout())
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("job exited with status %d", exitCode)
+	}
+
+	return cd.recordJobSuccess(conf)
+}
+
+// processJobDefinition processes a job definition, pulling its image and
+// running it if its spec or image has changed since it last completed
+// successfully.
+func processJobDefinition(conf *config.Configuration, cd *ContainerDefinition) *Result {
+
+	result, started := newResult(cd.ContainerName)
+
+	if err := cd.pullImage(conf); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Error("Unable to pull docker image")
+		result.Action = ActionPull
+		return result.finish(started, err)
+	}
+
+	pending, reason := cd.jobPending(conf)
+	if !pending {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+		}).Debug("Job already completed, no action taken")
+		return result.finish(started, nil)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"container_name": cd.ContainerName,
+		"reason":         reason,
+	}).Info("Running job")
+
+	result.Action = ActionRun
+	result.ImageIDBefore = cd.currentImageID()
+	err := cd.runJob(conf)
+	result.ImageIDAfter = cd.currentImageID()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Error("Job failed")
+	}
+
+	return result.finish(started, err)
+}
@@ -0,0 +1,146 @@
+package containerdefs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// labels applied to every container started by oneill, marking it as managed
+// by a particular oneill instance. Only containers carrying these labels are
+// ever considered for removal.
+const (
+	LabelInstance     = "io.rehabstudio.oneill.instance"
+	LabelDefinition   = "io.rehabstudio.oneill.definition"
+	LabelSpecHash     = "io.rehabstudio.oneill.spec-hash"
+	LabelDependsOn    = "io.rehabstudio.oneill.depends-on"
+	LabelReplica      = "io.rehabstudio.oneill.replica"
+	LabelScheduledRun = "io.rehabstudio.oneill.scheduled-run"
+	LabelRepoDigest   = "io.rehabstudio.oneill.repo-digest"
+)
+
+// specVersion is recorded in every container spec. Bumping it changes the
+// spec hash of every definition, recreating all containers on upgrade, so
+// it should only be done when that's actually wanted.
+const specVersion = 1
+
+// containerSpec is the part of a resolved container definition that reaches
+// docker, hashed to detect when a container needs recreating. Fields are
+// omitted when empty, so a new field doesn't change the hash of definitions
+// that don't use it. Settings that are only used by oneill itself (or are
+// host-wide, like the persistence directory) don't belong here.
+type containerSpec struct {
+	Version         int                        `json:"version"`
+	RepoTag         string                     `json:"repo_tag"`
+	Env             []string                   `json:"env,omitempty"`
+	Entrypoint      []string                   `json:"entrypoint,omitempty"`
+	Cmd             []string                   `json:"cmd,omitempty"`
+	WorkingDir      string                     `json:"working_dir,omitempty"`
+	User            string                     `json:"user,omitempty"`
+	DockerControl   bool                       `json:"docker_control,omitempty"`
+	PersistenceName string                     `json:"persistence_name,omitempty"`
+	Binds           []string                   `json:"binds,omitempty"`
+	PortMapping     map[int]int                `json:"port_mapping,omitempty"`
+	Healthcheck     *dockerclient.HealthConfig `json:"healthcheck,omitempty"`
+	RestartPolicy   string                     `json:"restart_policy,omitempty"`
+	RestartRetries  int                        `json:"restart_retries,omitempty"`
+	StopSignal      string                     `json:"stop_signal,omitempty"`
+	StopTimeout     time.Duration              `json:"stop_timeout,omitempty"`
+	Resources       *dockerclient.Resources    `json:"resources,omitempty"`
+}
+
+// SpecHash returns a hash of the fully resolved container definition,
+// allowing a running container to be traced back to the exact definition it
+// was created from. Only settings that affect the container itself are
+// included (see containerSpec), so changes to e.g. a definition's
+// pull_policy don't cause its container to be recreated.
+func (cd *ContainerDefinition) SpecHash(conf *config.Configuration) string {
+
+	opts := cd.resolvedOptions(conf)
+	spec := containerSpec{
+		Version:        specVersion,
+		RepoTag:        opts.RepoTag,
+		Entrypoint:     opts.Entrypoint,
+		Cmd:            opts.Cmd,
+		WorkingDir:     opts.WorkingDir,
+		User:           opts.User,
+		DockerControl:  opts.DockerControlEnabled,
+		Binds:          opts.Binds,
+		PortMapping:    opts.PortMapping,
+		Healthcheck:    opts.Healthcheck,
+		RestartPolicy:  opts.RestartPolicy.Name,
+		RestartRetries: opts.RestartPolicy.MaximumRetryCount,
+		StopSignal:     opts.StopSignal,
+		StopTimeout:    opts.StopTimeout,
+	}
+	if opts.PersistenceEnabled {
+		spec.PersistenceName = opts.PersistenceName
+	}
+	if opts.Resources != (dockerclient.Resources{}) {
+		spec.Resources = &opts.Resources
+	}
+
+	// environment variables are unmarshalled from a map so their order isn't
+	// stable, sort a copy before hashing
+	spec.Env = append([]string{}, opts.Env...)
+	sort.Strings(spec.Env)
+
+	data, _ := json.Marshal(spec)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Labels returns the ownership labels that should be applied to a container
+// started from this definition.
+func (cd *ContainerDefinition) Labels(conf *config.Configuration) map[string]string {
+
+	labels := map[string]string{
+		LabelInstance:   conf.InstanceID,
+		LabelDefinition: cd.ContainerName,
+		LabelSpecHash:   cd.SpecHash(conf),
+	}
+
+	// replicas are labelled with the definition they were expanded from
+	if cd.DefinitionName != "" {
+		labels[LabelDefinition] = cd.DefinitionName
+		labels[LabelReplica] = strconv.Itoa(cd.ReplicaIndex)
+	}
+
+	// record dependencies so that containers can be removed in the correct
+	// order even once their definitions no longer exist
+	if len(cd.DependsOn) > 0 {
+		labels[LabelDependsOn] = strings.Join(cd.dependencyNames(), ",")
+	}
+
+	return labels
+}
+
+// checkOwnership returns an error if a container with this definition's name
+// exists but is labelled as managed by a different oneill instance. Such a
+// container must never be replaced or removed. Unlabelled containers (started
+// before oneill labelled its containers) are adopted as usual.
+func (cd *ContainerDefinition) checkOwnership(conf *config.Configuration) error {
+
+	c, err := dockerclient.GetContainerByName(cd.ContainerName)
+	if err != nil {
+		return nil
+	}
+
+	labels, err := dockerclient.ContainerLabels(c.ID)
+	if err != nil {
+		return err
+	}
+	if owner := labels[LabelInstance]; owner != "" && owner != conf.InstanceID {
+		return fmt.Errorf("container %s is managed by another oneill instance (%s)", cd.ContainerName, owner)
+	}
+
+	return nil
+}
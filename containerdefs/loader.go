@@ -2,6 +2,10 @@ package containerdefs
 
 import (
 	"fmt"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rehabstudio/oneill/config"
 )
 
 type DefinitionLoader interface {
@@ -11,40 +15,95 @@ type DefinitionLoader interface {
 
 // LoadContainerDefinitions scans a local directory (might have been passed from the command line)
 // for container definitions, reads them into memory and unmarshalls them into ContainerDefinition
-// structs.
-func LoadContainerDefinitions(loader DefinitionLoader) ([]*ContainerDefinition, error) {
+// structs. The names of any definitions that failed validation (including those that aren't
+// allowed by oneill's configuration) are also returned, so that their existing containers can be
+// kept rather than removed.
+func LoadContainerDefinitions(conf *config.Configuration, loader DefinitionLoader) ([]*ContainerDefinition, []string, error) {
 
 	// validate the uri that's been passed to the definition, this might be ensuring that a given
 	// directory exists or that a url returns a 200 status code.
 	if err := loader.ValidateURI(); err != nil {
-		return []*ContainerDefinition{}, err
+		return []*ContainerDefinition{}, nil, err
 	}
 
 	// load container definitions. By default this is from disk, but could be from a remote
 	// location if a loader for that source exists.
 	definitions, err := loader.LoadContainerDefinitions()
 	if err != nil {
-		return definitions, err
+		return definitions, nil, err
 	}
 
 	// validate all container definitions individually, dropping any that
 	// don't pass validation
 	var definitionsValidated []*ContainerDefinition
+	var invalid []string
 	for _, definition := range definitions {
-		if definition.Validate() {
+		if definition.Validate() && definitionAllowed(conf, definition) {
 			definitionsValidated = append(definitionsValidated, definition)
+		} else if definition.ContainerName != "" {
+			invalid = append(invalid, definition.ContainerName)
 		}
 	}
 
+	// expand definitions with replicas into one definition per replica, all
+	// further validation works on the expanded names
+	definitionsValidated = expandReplicas(definitionsValidated)
+
 	// validate container definitions as a group, if this doesn't pass then we
 	// bail out since it's impossible to know what the user meant to do.
-	for _, definition := range definitionsValidated {
-		if !definitionIsUnique(definition, definitionsValidated) {
-			return []*ContainerDefinition{}, fmt.Errorf("Container definitions clash (name or ports): %s", definition.ContainerName)
+	if err := validateGroup(definitionsValidated); err != nil {
+		return []*ContainerDefinition{}, nil, err
+	}
+
+	return definitionsValidated, invalid, nil
+}
+
+// ValidateContainerDefinitions runs the same checks as
+// LoadContainerDefinitions against definitions that have already been loaded
+// and expanded, e.g. those recorded in a previous revision. Since there's
+// nothing to fall back to, any definition failing validation is an error
+// rather than being dropped.
+func ValidateContainerDefinitions(conf *config.Configuration, cdefs []*ContainerDefinition) error {
+
+	for _, definition := range cdefs {
+		if !definition.Validate() || !definitionAllowed(conf, definition) {
+			return fmt.Errorf("Container definition failed validation: %s", definition.ContainerName)
 		}
 	}
 
-	return definitionsValidated, nil
+	return validateGroup(cdefs)
+}
+
+// validateGroup checks that a set of validated container definitions is
+// consistent as a whole: that names and ports don't clash, and that there are
+// no unknown references or cycles in their dependencies (either of which
+// makes it impossible to decide which order to start things in).
+func validateGroup(cdefs []*ContainerDefinition) error {
+
+	for _, definition := range cdefs {
+		if !definitionIsUnique(definition, cdefs) {
+			return fmt.Errorf("Container definitions clash (name or ports): %s", definition.ContainerName)
+		}
+	}
+
+	return validateDependencies(cdefs)
+}
+
+// definitionAllowed checks that a container definition only uses features
+// allowed by oneill's configuration, i.e. that it only bind mounts host paths
+// in the bind_mount_allowlist. Definitions can come from remote sources, so
+// they can't be trusted to mount anything from the host.
+func definitionAllowed(conf *config.Configuration, cd *ContainerDefinition) bool {
+
+	if err := cd.volumesAllowed(conf); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Warning("container definition not allowed")
+		return false
+	}
+
+	return true
 }
 
 func definitionIsUnique(cd *ContainerDefinition, cds []*ContainerDefinition) bool {
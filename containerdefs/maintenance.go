@@ -0,0 +1,239 @@
+package containerdefs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// actions taken instead of changes while changes are frozen
+const (
+	ActionDefer   Action = "defer"
+	ActionRestart Action = "restart"
+)
+
+// drift reasons that can be fixed without changing a container, by
+// restarting it. These are the only problems fixed while changes are frozen.
+const (
+	reasonNotRunning = "container not running"
+	reasonUnhealthy  = "container unhealthy"
+)
+
+// weekdays maps the day names used in maintenance windows to time.Weekday
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseClock parses a time of day in the form `15:04`, returning the number
+// of minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("not a valid time of day: %s", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ValidateMaintenanceWindows checks that every configured maintenance window
+// can be parsed.
+func ValidateMaintenanceWindows(conf *config.Configuration) error {
+
+	for _, w := range conf.MaintenanceWindows {
+		for _, day := range w.Days {
+			if _, ok := weekdays[strings.ToLower(day)]; !ok {
+				return fmt.Errorf("not a valid maintenance window day: %s", day)
+			}
+		}
+		if _, err := parseClock(w.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(w.End); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inWindow reports whether t falls within a single maintenance window.
+// Windows that end before they start span midnight, and belong to the day
+// they start on.
+func inWindow(w config.MaintenanceWindow, t time.Time) bool {
+
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	now := t.Hour()*60 + t.Minute()
+
+	day := t.Weekday()
+	switch {
+	case start <= end:
+		if now < start || now >= end {
+			return false
+		}
+	case now >= start:
+	case now < end:
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+// changesFrozen reports whether changes to containers are currently frozen,
+// either because the freeze file exists or because it's outside all of the
+// configured maintenance windows, along with the reason.
+func changesFrozen(conf *config.Configuration) (bool, string) {
+
+	if conf.FreezeFile != "" {
+		if _, err := os.Stat(conf.FreezeFile); err == nil {
+			return true, fmt.Sprintf("freeze file %s exists", conf.FreezeFile)
+		}
+	}
+
+	if len(conf.MaintenanceWindows) == 0 {
+		return false, ""
+	}
+	now := time.Now()
+	for _, w := range conf.MaintenanceWindows {
+		if inWindow(w, now) {
+			return false, ""
+		}
+	}
+
+	return true, "outside maintenance windows"
+}
+
+// splitReasons separates the drift reasons that can be fixed by restarting
+// the existing container from those that need it to be changed.
+func splitReasons(reasons []string) ([]string, []string) {
+
+	var safety, changes []string
+	for _, reason := range reasons {
+		if reason == reasonNotRunning || reason == reasonUnhealthy {
+			safety = append(safety, reason)
+		} else {
+			changes = append(changes, reason)
+		}
+	}
+
+	return safety, changes
+}
+
+// allowedWhileFrozen reports whether an action can go ahead while changes are
+// frozen. Restarts always can, and so can starting a missing container as
+// long as its image doesn't need to be pulled first.
+func allowedWhileFrozen(action Action, imagePresent bool) bool {
+	switch action {
+	case ActionNone, ActionRestart:
+		return true
+	case ActionStart:
+		return imagePresent
+	}
+	return false
+}
+
+// deferred logs a change that wasn't made because changes are frozen.
+func deferred(containerName, frozen string, reasons []string) {
+	logrus.WithFields(logrus.Fields{
+		"container_name": containerName,
+		"frozen":         frozen,
+		"reasons":        strings.Join(reasons, "; "),
+	}).Warning("Changes frozen, deferring")
+}
+
+// processFrozen processes a container definition while changes are frozen.
+// No images are pulled and no containers are replaced or removed, but a
+// crashed or unhealthy container is restarted as it is, and a missing
+// container is recreated if its image is already present locally. Everything
+// else is deferred until changes are allowed again.
+func (cd *ContainerDefinition) processFrozen(conf *config.Configuration, frozen string) *Result {
+
+	result, started := newResult(cd.ContainerName)
+
+	exists, reasons := cd.Drift(conf)
+	if !exists {
+		if _, err := dockerclient.InspectImage(cd.RepoTag); err != nil {
+			deferred(cd.ContainerName, frozen, append(reasons, fmt.Sprintf("image not present locally (%s)", cd.RepoTag)))
+			result.Action = ActionDefer
+			return result.finish(started, nil)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+		}).Info("Container missing, starting from local image")
+
+		result.Action = ActionStart
+		err := cd.StartContainer(conf)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"err":            err,
+			}).Error("Unable to start docker container")
+		}
+		result.ImageIDAfter = cd.currentImageID()
+		return result.finish(started, err)
+	}
+
+	safety, changes := splitReasons(reasons)
+	if len(changes) > 0 {
+		deferred(cd.ContainerName, frozen, changes)
+		result.Action = ActionDefer
+	}
+	if len(safety) == 0 {
+		return result.finish(started, nil)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"container_name": cd.ContainerName,
+		"reasons":        strings.Join(safety, "; "),
+	}).Info("Restarting docker container")
+
+	result.Action = ActionRestart
+	c, err := dockerclient.GetContainerByName(cd.ContainerName)
+	if err == nil {
+		err = dockerclient.RestartContainer(c.ID, cd.stopTimeout())
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Error("Unable to restart docker container")
+	}
+
+	return result.finish(started, err)
+}
+
+// processFrozenJob processes a job definition while changes are frozen. Jobs
+// only run when they change, so a pending job is always deferred.
+func (cd *ContainerDefinition) processFrozenJob(conf *config.Configuration, frozen string) *Result {
+
+	result, started := newResult(cd.ContainerName)
+	if pending, reason := cd.jobPending(conf); pending {
+		deferred(cd.ContainerName, frozen, []string{reason})
+		result.Action = ActionDefer
+	}
+
+	return result.finish(started, nil)
+}
@@ -0,0 +1,83 @@
+package containerdefs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rehabstudio/oneill/config"
+)
+
+func TestInWindow(t *testing.T) {
+
+	// 2024-01-05 was a friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+	}
+	window := func(start, end string, days ...string) config.MaintenanceWindow {
+		return config.MaintenanceWindow{Days: days, Start: start, End: end}
+	}
+
+	tests := []struct {
+		window config.MaintenanceWindow
+		t      time.Time
+		in     bool
+	}{
+		// windows within a single day, start inclusive and end exclusive
+		{window("02:00", "04:00"), at(5, 1, 59), false},
+		{window("02:00", "04:00"), at(5, 2, 0), true},
+		{window("02:00", "04:00"), at(5, 3, 59), true},
+		{window("02:00", "04:00"), at(5, 4, 0), false},
+		{window("02:00", "02:00"), at(5, 2, 0), false},
+
+		// restricted to certain days
+		{window("02:00", "04:00", "fri"), at(5, 3, 0), true},
+		{window("02:00", "04:00", "Fri"), at(5, 3, 0), true},
+		{window("02:00", "04:00", "mon", "fri"), at(5, 3, 0), true},
+		{window("02:00", "04:00", "sat"), at(5, 3, 0), false},
+
+		// windows past midnight
+		{window("22:00", "02:00"), at(5, 21, 59), false},
+		{window("22:00", "02:00"), at(5, 22, 0), true},
+		{window("22:00", "02:00"), at(5, 23, 59), true},
+		{window("22:00", "02:00"), at(6, 0, 0), true},
+		{window("22:00", "02:00"), at(6, 1, 59), true},
+		{window("22:00", "02:00"), at(6, 2, 0), false},
+		{window("22:00", "02:00"), at(6, 12, 0), false},
+
+		// which belong to the day they start on
+		{window("22:00", "02:00", "fri"), at(5, 23, 0), true},
+		{window("22:00", "02:00", "fri"), at(6, 1, 0), true},
+		{window("22:00", "02:00", "fri"), at(5, 1, 0), false},
+		{window("22:00", "02:00", "fri"), at(6, 23, 0), false},
+		{window("22:00", "02:00", "sat"), at(6, 1, 0), false},
+		{window("22:00", "02:00", "sun"), at(1, 1, 0), true},
+	}
+
+	for _, test := range tests {
+		if inWindow(test.window, test.t) != test.in {
+			t.Errorf("Expected %s in window %v to be %t", test.t.Format("Mon 15:04"), test.window, test.in)
+		}
+	}
+}
+
+func TestValidateMaintenanceWindows(t *testing.T) {
+
+	tests := []struct {
+		window config.MaintenanceWindow
+		valid  bool
+	}{
+		{config.MaintenanceWindow{Start: "02:00", End: "04:00"}, true},
+		{config.MaintenanceWindow{Days: []string{"sat", "SUN"}, Start: "22:00", End: "02:00"}, true},
+		{config.MaintenanceWindow{Days: []string{"saturday"}, Start: "02:00", End: "04:00"}, false},
+		{config.MaintenanceWindow{Start: "2am", End: "04:00"}, false},
+		{config.MaintenanceWindow{Start: "02:00", End: "24:00"}, false},
+		{config.MaintenanceWindow{Start: "02:00"}, false},
+	}
+
+	for _, test := range tests {
+		conf := &config.Configuration{MaintenanceWindows: []config.MaintenanceWindow{test.window}}
+		if err := ValidateMaintenanceWindows(conf); (err == nil) != test.valid {
+			t.Errorf("Expected window %v to be valid: %t, got error %v", test.window, test.valid, err)
+		}
+	}
+}
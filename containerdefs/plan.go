@@ -0,0 +1,245 @@
+package containerdefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// Action describes a single change oneill would make to bring the running
+// containers in line with the loaded container definitions.
+type Action string
+
+const (
+	ActionNone     Action = "none"
+	ActionPull     Action = "pull"
+	ActionRecreate Action = "recreate"
+	ActionRemove   Action = "remove"
+	ActionStart    Action = "start"
+)
+
+// PlannedAction is a single entry in a Plan, describing what would happen to
+// a container and why. There's only ever one entry per container. RepoTag
+// and RepoDigest identify the image involved: the image that would be
+// deployed, or the image of a container that would be removed. Pull is set
+// if the image would have to be pulled first.
+type PlannedAction struct {
+	ContainerName string   `json:"container_name"`
+	Action        Action   `json:"action"`
+	RepoTag       string   `json:"repo_tag,omitempty"`
+	RepoDigest    string   `json:"repo_digest,omitempty"`
+	Pull          bool     `json:"pull,omitempty"`
+	Reasons       []string `json:"reasons,omitempty"`
+}
+
+// Plan is the full set of actions oneill would take during a run, computed
+// without making any changes to the running containers.
+type Plan struct {
+	Actions []*PlannedAction `json:"actions"`
+}
+
+// add appends a new action to the plan, returning it so that the image
+// details can be filled in
+func (p *Plan) add(containerName string, action Action, reasons ...string) *PlannedAction {
+	a := &PlannedAction{
+		ContainerName: containerName,
+		Action:        action,
+		Reasons:       reasons,
+	}
+	p.Actions = append(p.Actions, a)
+	return a
+}
+
+// image returns the image involved in a planned action, showing the digest
+// alongside the tag where it's known.
+func (a *PlannedAction) image() string {
+	if _, _, digest := dockerclient.ParseImageReference(a.RepoTag); digest != "" || a.RepoDigest == "" {
+		return a.RepoTag
+	}
+	return a.RepoTag + "@" + shortDigest(a.RepoDigest)
+}
+
+// HasChanges reports whether applying the plan would change anything on the
+// host. Deferred actions aren't changes, they won't happen until changes are
+// allowed again.
+func (p *Plan) HasChanges() bool {
+	for _, a := range p.Actions {
+		if a.Action != ActionNone && a.Action != ActionDefer {
+			return true
+		}
+	}
+	return false
+}
+
+// count returns the number of planned actions of the given type.
+func (p *Plan) count(action Action) int {
+	var n int
+	for _, a := range p.Actions {
+		if a.Action == action {
+			n = n + 1
+		}
+	}
+	return n
+}
+
+// pulls returns the number of images that would be pulled.
+func (p *Plan) pulls() int {
+	var n int
+	for _, a := range p.Actions {
+		if a.Pull {
+			n = n + 1
+		}
+	}
+	return n
+}
+
+// WriteText writes a human readable representation of the plan to w.
+func (p *Plan) WriteText(w io.Writer) error {
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, a := range p.Actions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Action, a.ContainerName, a.image(), strings.Join(a.Reasons, "; "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\nPlan: %d to remove, %d to pull, %d to recreate, %d to start, %d to run, %d to restart, %d deferred, %d unchanged.\n",
+		p.count(ActionRemove), p.pulls(), p.count(ActionRecreate), p.count(ActionStart), p.count(ActionRun), p.count(ActionRestart), p.count(ActionDefer), p.count(ActionNone))
+	return err
+}
+
+// WriteJSON writes the plan to w as a JSON document.
+func (p *Plan) WriteJSON(w io.Writer) error {
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// BuildPlan computes every action that a normal run would take for the given
+// container definitions, along with the reasons for each one. Docker is only
+// queried, no containers or images are changed.
+func BuildPlan(conf *config.Configuration, cdefs []*ContainerDefinition, keep []string) (*Plan, error) {
+
+	plan := &Plan{}
+
+	// while changes are frozen anything other than restoring crashed or
+	// missing containers is deferred, the reason for the freeze is added to each
+	// deferred action
+	frozen, frozenReason := changesFrozen(conf)
+
+	// containers that would be removed by RemoveRedundantContainers
+	containers, managed, err := redundantContainers(conf, cdefs, keep)
+	if err != nil {
+		return plan, err
+	}
+	for _, c := range containers {
+		a := plan.add(strings.TrimPrefix(c.Names[0], "/"), ActionRemove, "not present in container definitions")
+		if frozen {
+			a.Action = ActionDefer
+			a.Reasons = append(a.Reasons, frozenReason)
+		}
+		a.RepoTag = c.Image
+		if labels, err := dockerclient.ContainerLabels(c.ID); err == nil {
+			a.RepoDigest = labels[LabelRepoDigest]
+		}
+	}
+	if err := checkRemovalLimits(conf, len(containers), managed); err != nil && !frozen {
+		logrus.WithFields(logrus.Fields{"err": err}).Warning("Applying this plan would be aborted")
+	}
+
+	for _, cd := range cdefs {
+
+		// every action for a definition involves the image that's currently
+		// available locally for its repo_tag (if any)
+		digest, _ := dockerclient.ResolveRepoDigest(cd.RepoTag)
+		_, err := dockerclient.InspectImage(cd.RepoTag)
+		imagePresent := err == nil
+
+		// we can't know whether a newer image is available without actually
+		// pulling it, so we only report pulls that are strictly required.
+		// Every action that deploys the image has to pull it first.
+		var pull bool
+		var pullReason string
+		if !imagePresent {
+			pull = cd.shouldPull(conf, false)
+			pullReason = "image will be pulled"
+			if !pull {
+				pullReason = "image can't be pulled (pull_policy is never)"
+			}
+		}
+		add := func(action Action, reasons ...string) *PlannedAction {
+			if frozen && !allowedWhileFrozen(action, imagePresent) {
+				action, reasons = ActionDefer, append(reasons, frozenReason)
+			}
+			a := plan.add(cd.ContainerName, action, reasons...)
+			a.RepoTag = cd.RepoTag
+			a.RepoDigest = digest
+			return a
+		}
+		deploy := func(action Action, reasons ...string) {
+			if pullReason != "" {
+				reasons = append(reasons, pullReason)
+			}
+			if a := add(action, reasons...); a.Action != ActionDefer {
+				a.Pull = pull
+			}
+		}
+
+		if cd.IsScheduled() {
+			add(ActionNone, fmt.Sprintf("scheduled job (%s)", cd.Schedule))
+			continue
+		}
+
+		if err := cd.checkOwnership(conf); err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Warning("Applying this plan would fail")
+			add(ActionNone, err.Error())
+			continue
+		}
+
+		if cd.IsJob() {
+			if pending, reason := cd.jobPending(conf); pending {
+				deploy(ActionRun, reason)
+			} else {
+				add(ActionNone)
+			}
+			continue
+		}
+
+		exists, reasons := cd.explainDrift(conf)
+		switch {
+		case !exists:
+			deploy(ActionStart, reasons...)
+		case len(reasons) > 0 && frozen:
+			// restarting doesn't need a new image, anything else that's
+			// wrong with the container is deferred
+			safety, changes := splitReasons(reasons)
+			switch {
+			case len(safety) == 0:
+				deploy(ActionDefer, changes...)
+			case len(changes) > 0:
+				add(ActionRestart, append(safety, fmt.Sprintf("deferred (%s): %s", frozenReason, strings.Join(changes, "; ")))...)
+			default:
+				add(ActionRestart, safety...)
+			}
+		case len(reasons) > 0:
+			deploy(ActionRecreate, reasons...)
+		default:
+			add(ActionNone)
+		}
+	}
+
+	return plan, nil
+}
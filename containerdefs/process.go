@@ -1,7 +1,9 @@
 package containerdefs
 
 import (
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 
@@ -9,21 +11,97 @@ import (
 	"github.com/rehabstudio/oneill/dockerclient"
 )
 
+// currentImageID returns the ID of the image used by the existing container
+// for this definition, or an empty string if there is no such container.
+func (cd *ContainerDefinition) currentImageID() string {
+
+	c, err := dockerclient.GetContainerByName(cd.ContainerName)
+	if err != nil {
+		return ""
+	}
+	container, err := dockerclient.InspectContainer(c.ID)
+	if err != nil {
+		return ""
+	}
+
+	return container.Image
+}
+
 // processContainerDefinition processes an individual container definition,
-// first pulling the image, then starting a new container if necessary.
-func processContainerDefinition(conf *config.Configuration, cd *ContainerDefinition) {
+// first pulling the image, then starting a new container if necessary. Any
+// error is logged and recorded in the returned result.
+func processContainerDefinition(conf *config.Configuration, cd *ContainerDefinition) *Result {
 
-	// pull docker image if available (doesn't matter if not, we'll fail later)
-	dockerclient.PullImage(cd.RepoTag)
+	// scheduled jobs are only ever run by the daemon's scheduler
+	if cd.IsScheduled() {
+		result, started := newResult(cd.ContainerName)
+		return result.finish(started, nil)
+	}
+
+	// a container with the same name belonging to another oneill instance
+	// is left alone, the definition can't be applied until it's renamed
+	if err := cd.checkOwnership(conf); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Error("Unable to process container definition")
+		result, started := newResult(cd.ContainerName)
+		return result.finish(started, err)
+	}
+
+	// while changes are frozen only crashed or missing containers are
+	// restored, anything else waits for the next maintenance window
+	if frozen, reason := changesFrozen(conf); frozen {
+		if cd.IsJob() {
+			return cd.processFrozenJob(conf, reason)
+		}
+		return cd.processFrozen(conf, reason)
+	}
+
+	if cd.IsJob() {
+		return processJobDefinition(conf, cd)
+	}
+
+	result, started := newResult(cd.ContainerName)
+	result.ImageIDBefore = cd.currentImageID()
+	defer func() {
+		result.ImageIDAfter = cd.currentImageID()
+	}()
+
+	if err := cd.pullImage(conf); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Error("Unable to pull docker image")
+		result.Action = ActionPull
+		return result.finish(started, err)
+	}
 
 	// check if an already existing container matches the spec of the
 	// container we want to start, if so then we can stop processing this
 	// definition.
-	if cd.AlreadyRunning(conf.PersistenceDirectory) {
+	if cd.AlreadyRunning(conf) {
 		logrus.WithFields(logrus.Fields{
 			"container_name": cd.ContainerName,
 		}).Debug("Container already running, no action taken")
-		return
+		return result.finish(started, nil)
+	}
+
+	result.Action = ActionStart
+	if result.ImageIDBefore != "" {
+		result.Action = ActionRecreate
+	}
+
+	// replace the running container without downtime if configured to do so
+	if cd.useBlueGreen() {
+		err := cd.blueGreenReplace(conf)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"err":            err,
+			}).Error("Unable to replace docker container")
+		}
+		return result.finish(started, err)
 	}
 
 	// remove container if one is running with the same name since we know
@@ -34,35 +112,119 @@ func processContainerDefinition(conf *config.Configuration, cd *ContainerDefinit
 			"container_name": cd.ContainerName,
 			"err":            err,
 		}).Error("Unable to remove docker container")
-		return
+		return result.finish(started, err)
 	}
 
 	// create and start the new container
-	err = cd.StartContainer(conf.PersistenceDirectory)
+	err = cd.StartContainer(conf)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"container_name": cd.ContainerName,
 			"err":            err,
 		}).Error("Unable to start docker container")
-		return
+		return result.finish(started, err)
 	}
+
+	return result.finish(started, nil)
 }
 
 // ProcessContainerDefinitions runs a goroutine for each definition, pulling
-// images, validating them and starting containers if necessary.
-func ProcessContainerDefinitions(conf *config.Configuration, cdefs []*ContainerDefinition) error {
+// images, validating them and starting containers if necessary. Each
+// goroutine waits for the definitions it depends on to be processed first,
+// so containers are started in dependency order while independent
+// containers are still processed concurrently. Dependencies that aren't
+// among the given definitions are assumed to be satisfied. The outcome of
+// each definition is returned in the same order as the definitions.
+func ProcessContainerDefinitions(conf *config.Configuration, cdefs []*ContainerDefinition) []*Result {
+
+	// each definition gets a channel that is closed once it's been processed
+	byName := make(map[string]*ContainerDefinition)
+	done := make(map[string]chan struct{})
+	for _, cdef := range cdefs {
+		byName[cdef.ContainerName] = cdef
+		done[cdef.ContainerName] = make(chan struct{})
+	}
+
+	// results are only written by the goroutine processing each definition
+	// and only read by dependents once that goroutine has finished
+	results := make([]*Result, len(cdefs))
+	resultsByName := make(map[string]*Result)
+	var resultsMu sync.Mutex
+	resultFor := func(name string) *Result {
+		resultsMu.Lock()
+		defer resultsMu.Unlock()
+		return resultsByName[name]
+	}
 
 	// process all container definitions concurrently
 	var wg sync.WaitGroup
-	for _, cdef := range cdefs {
+	for i, cdef := range cdefs {
 		wg.Add(1)
-		go func(cdef *ContainerDefinition) {
+		go func(i int, cdef *ContainerDefinition) {
 			defer wg.Done()
-			processContainerDefinition(conf, cdef)
-		}(cdef)
+			defer close(done[cdef.ContainerName])
+
+			var result *Result
+			defer func() {
+				resultsMu.Lock()
+				defer resultsMu.Unlock()
+				results[i] = result
+				resultsByName[cdef.ContainerName] = result
+			}()
+
+			for _, dep := range cdef.DependsOn {
+				depDone, ok := done[dep.Name]
+				if !ok {
+					continue
+				}
+				<-depDone
+
+				var err error
+				if depResult := resultFor(dep.Name); depResult.Failed() {
+					err = fmt.Errorf("dependency %s failed", dep.Name)
+				} else {
+					err = waitForDependency(dep, byName[dep.Name])
+				}
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"container_name": cdef.ContainerName,
+						"dependency":     dep.Name,
+						"err":            err,
+					}).Error("Dependency not available, skipping container")
+					var started time.Time
+					result, started = newResult(cdef.ContainerName)
+					result.Action = ActionSkip
+					result.finish(started, err)
+					return
+				}
+			}
+
+			result = processContainerDefinition(conf, cdef)
+		}(i, cdef)
 	}
 
 	// wait for all goroutines to complete before returning
 	wg.Wait()
-	return nil
+	return results
+}
+
+// ProcessContainerDefinitionsByName behaves like ProcessContainerDefinitions
+// but only processes the definitions with one of the given names. Names that
+// don't match a definition are ignored, as are jobs (job containers are
+// expected to exit, and are only run during a full reconcile).
+func ProcessContainerDefinitionsByName(conf *config.Configuration, cdefs []*ContainerDefinition, names []string) []*Result {
+
+	var targets []*ContainerDefinition
+	for _, cdef := range cdefs {
+		if cdef.IsJob() {
+			continue
+		}
+		for _, name := range names {
+			if cdef.ContainerName == name {
+				targets = append(targets, cdef)
+			}
+		}
+	}
+
+	return ProcessContainerDefinitions(conf, targets)
 }
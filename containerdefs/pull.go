@@ -0,0 +1,156 @@
+package containerdefs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// pull policies supported by container definitions. `interval:<duration>`
+// policies are also supported, e.g. `interval:1h`.
+const (
+	PullAlways       = "always"
+	PullIfNotPresent = "if_not_present"
+	PullNever        = "never"
+
+	pullIntervalPrefix = "interval:"
+)
+
+// parsePullPolicy splits a pull policy into its name and, for interval
+// policies, the minimum time between pulls.
+func parsePullPolicy(policy string) (string, time.Duration, error) {
+
+	switch policy {
+	case "":
+		return PullAlways, 0, nil
+	case PullAlways, PullIfNotPresent, PullNever:
+		return policy, 0, nil
+	}
+
+	if strings.HasPrefix(policy, pullIntervalPrefix) {
+		interval, err := time.ParseDuration(strings.TrimPrefix(policy, pullIntervalPrefix))
+		if err != nil || interval <= 0 {
+			return "", 0, fmt.Errorf("not a valid pull interval: %s", policy)
+		}
+		return pullIntervalPrefix, interval, nil
+	}
+
+	return "", 0, fmt.Errorf("not a valid pull policy: %s", policy)
+}
+
+// pullOptions returns the retry and timeout settings used for every pull.
+// Both durations are validated when the configuration is loaded.
+func pullOptions(conf *config.Configuration) dockerclient.PullOptions {
+	backoff, _ := time.ParseDuration(conf.PullBackoff)
+	timeout, _ := time.ParseDuration(conf.PullTimeout)
+	return dockerclient.PullOptions{
+		Attempts: conf.PullAttempts,
+		Backoff:  backoff,
+		Timeout:  timeout,
+	}
+}
+
+// pullStatePath returns the path of the file used to record when this
+// definition's image was last pulled.
+func pullStatePath(conf *config.Configuration, name string) string {
+	return path.Join(conf.StateDirectory, "pulls", name)
+}
+
+// lastPulled returns the time this definition's image was last pulled, or
+// the zero time if it never has been.
+func (cd *ContainerDefinition) lastPulled(conf *config.Configuration) time.Time {
+
+	data, err := ioutil.ReadFile(pullStatePath(conf, cd.ContainerName))
+	if err != nil {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// recordPull records that this definition's image was pulled just now.
+func (cd *ContainerDefinition) recordPull(conf *config.Configuration) error {
+
+	statePath := pullStatePath(conf, cd.ContainerName)
+	if err := os.MkdirAll(path.Dir(statePath), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(statePath, []byte(time.Now().Format(time.RFC3339)+"\n"), 0644)
+}
+
+// shouldPull decides whether this definition's image should be pulled
+// according to its pull policy, given whether the image is already present.
+func (cd *ContainerDefinition) shouldPull(conf *config.Configuration, present bool) bool {
+
+	policy, interval, _ := parsePullPolicy(cd.PullPolicy)
+	switch policy {
+	case PullNever:
+		return false
+	case PullIfNotPresent:
+		return !present
+	case pullIntervalPrefix:
+		return !present || time.Since(cd.lastPulled(conf)) >= interval
+	default:
+		return true
+	}
+}
+
+// pullImage pulls the image for this definition according to its pull
+// policy and records it as used by oneill (images are only recorded as
+// pulled by oneill if they actually were). A failed pull is only an error if
+// the image isn't available locally, otherwise the local image is used.
+func (cd *ContainerDefinition) pullImage(conf *config.Configuration) error {
+
+	_, err := dockerclient.InspectImage(cd.RepoTag)
+	present := err == nil
+
+	var pulled bool
+	if cd.shouldPull(conf, present) {
+		err := dockerclient.PullImage(cd.RepoTag, pullOptions(conf))
+		switch {
+		case err == nil:
+			present, pulled = true, true
+			if err := cd.recordPull(conf); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"container_name": cd.ContainerName,
+					"err":            err,
+				}).Warning("Unable to record image pull")
+			}
+		case present:
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"repo_tag":       cd.RepoTag,
+				"err":            err,
+			}).Warning("Unable to pull image, using local image")
+		default:
+			return fmt.Errorf("unable to pull image %s: %s", cd.RepoTag, err)
+		}
+	}
+
+	if !present {
+		return fmt.Errorf("image %s not present locally and pull_policy is %s", cd.RepoTag, cd.PullPolicy)
+	}
+
+	if err := cd.recordImage(conf, pulled); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Debug("Unable to record pulled image")
+	}
+
+	return nil
+}
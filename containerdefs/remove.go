@@ -3,6 +3,10 @@ package containerdefs
 import (
 	"strings"
 
+	"github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+
+	"github.com/rehabstudio/oneill/config"
 	"github.com/rehabstudio/oneill/dockerclient"
 )
 
@@ -17,25 +21,166 @@ func nameInContainerDefs(name string, cdefs []*ContainerDefinition) bool {
 	return false
 }
 
-// RemoveRedundantContainers loops through all running docker containers and
-// stops/removes any that whose name doesn't match the name of one of the
-// container definitions passed into the function.
-func RemoveRedundantContainers(cdefs []*ContainerDefinition) error {
+// redundantContainers returns all existing docker containers managed by this
+// oneill instance whose name doesn't match the name of one of the container
+// definitions passed into the function, along with the total number of
+// containers managed by this instance. Containers that haven't been labelled
+// by this instance of oneill, or that match one of the configured ignore
+// rules, are never considered redundant. Neither are containers started from
+// one of the definitions in keep (definitions that failed validation). The
+// containers are returned in the order they should be removed.
+func redundantContainers(conf *config.Configuration, cdefs []*ContainerDefinition, keep []string) ([]docker.APIContainers, int, error) {
 
-	containers, err := dockerclient.ListContainers()
+	containers, err := dockerclient.ListContainersWithLabel(LabelInstance, conf.InstanceID)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
+	var managed int
+	var redundant []docker.APIContainers
 	for _, c := range containers {
+		if dockerclient.Ignored(c) {
+			continue
+		}
+		managed = managed + 1
+
 		cName := strings.TrimPrefix(c.Names[0], "/")
-		if !nameInContainerDefs(cName, cdefs) {
-			err := dockerclient.RemoveContainer(c)
-			if err != nil {
-				return err
-			}
+		if nameInContainerDefs(cName, cdefs) {
+			continue
+		}
+
+		// runs of scheduled jobs are named after the time they ran, they're
+		// only redundant once the scheduled job itself is no longer defined
+		labels, err := dockerclient.ContainerLabels(c.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if isScheduledRun(labels, cdefs) {
+			continue
+		}
+
+		// a definition that failed validation is most likely a typo, keep
+		// whatever was running before rather than removing it
+		if nameInList(labels[LabelDefinition], keep) {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cName,
+			}).Warning("Container definition failed validation, keeping existing container")
+			continue
+		}
+
+		redundant = append(redundant, c)
+	}
+
+	return sortForRemoval(redundant), managed, nil
+}
+
+// nameInList reports whether name is one of the given names
+func nameInList(name string, names []string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// removeContainers removes each of the given containers, continuing past any
+// failures and recording the outcome of each removal.
+func removeContainers(containers []docker.APIContainers) []*Result {
+
+	var results []*Result
+	for _, c := range containers {
+		result, started := newResult(strings.TrimPrefix(c.Names[0], "/"))
+		result.Action = ActionRemove
+		err := dockerclient.RemoveContainer(c, dockerclient.ContainerStopTimeout)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"container_name": result.ContainerName,
+				"err":            err,
+			}).Error("Unable to remove docker container")
+		}
+		results = append(results, result.finish(started, err))
+	}
+
+	return results
+}
+
+// deferRemovals records that each of the given containers would have been
+// removed if changes weren't frozen.
+func deferRemovals(containers []docker.APIContainers, frozen string) []*Result {
+
+	var results []*Result
+	for _, c := range containers {
+		result, started := newResult(strings.TrimPrefix(c.Names[0], "/"))
+		deferred(result.ContainerName, frozen, []string{"not present in container definitions"})
+		result.Action = ActionDefer
+		results = append(results, result.finish(started, nil))
+	}
+
+	return results
+}
+
+// RemoveRedundantContainers loops through all docker containers managed by
+// this oneill instance and stops/removes any whose name doesn't match the
+// name of one of the container definitions passed into the function.
+// Containers started from a definition in keep are left alone, and nothing is
+// removed while changes are frozen (see changesFrozen). Unless force
+// is set, nothing is removed if doing so would exceed the configured removal
+// limits. An error is only returned if the redundant containers couldn't be
+// determined or the limits were exceeded, the outcome of each removal is
+// recorded in the returned results.
+func RemoveRedundantContainers(conf *config.Configuration, cdefs []*ContainerDefinition, keep []string, force bool) ([]*Result, error) {
+
+	containers, managed, err := redundantContainers(conf, cdefs, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	if frozen, reason := changesFrozen(conf); frozen {
+		return deferRemovals(containers, reason), nil
+	}
+
+	if !force {
+		if err := checkRemovalLimits(conf, len(containers), managed); err != nil {
+			return nil, err
+		}
+	}
+
+	return removeContainers(containers), nil
+}
+
+// RemoveRedundantContainersByName behaves like RemoveRedundantContainers but
+// only removes containers with one of the given names. The removal limits are
+// checked against every redundant container, not just the named ones, so a
+// removal that was refused for a full run can't be done piecemeal as events
+// arrive for the containers involved.
+func RemoveRedundantContainersByName(conf *config.Configuration, cdefs []*ContainerDefinition, keep []string, force bool, names []string) ([]*Result, error) {
+
+	containers, managed, err := redundantContainers(conf, cdefs, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []docker.APIContainers
+	for _, c := range containers {
+		if nameInList(strings.TrimPrefix(c.Names[0], "/"), names) {
+			targets = append(targets, c)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	if frozen, reason := changesFrozen(conf); frozen {
+		return deferRemovals(targets, reason), nil
+	}
+
+	if !force {
+		if err := checkRemovalLimits(conf, len(containers), managed); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	return removeContainers(targets), nil
 }
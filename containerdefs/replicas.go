@@ -0,0 +1,71 @@
+package containerdefs
+
+import (
+	"fmt"
+)
+
+// EnvReplicaIndex is the environment variable used to pass each replica its
+// index (starting from 1).
+const EnvReplicaIndex = "ONEILL_REPLICA_INDEX"
+
+// replicaName returns the container name used for the replica with the given
+// index.
+func replicaName(name string, index int) string {
+	return fmt.Sprintf("%s-%d", name, index)
+}
+
+// expandReplicas replaces every definition that sets `replicas` with one
+// definition per replica, named `{container_name}-{index}`. Each replica is
+// passed its index in an environment variable, and dependencies on a
+// replicated definition are expanded to depend on every replica.
+func expandReplicas(cdefs []*ContainerDefinition) []*ContainerDefinition {
+
+	// map each definition name to the names of the containers it expands to
+	expandedNames := make(map[string][]string)
+	for _, cd := range cdefs {
+		if cd.Replicas == 0 {
+			continue
+		}
+		for i := 1; i <= cd.Replicas; i++ {
+			expandedNames[cd.ContainerName] = append(expandedNames[cd.ContainerName], replicaName(cd.ContainerName, i))
+		}
+	}
+
+	var expanded []*ContainerDefinition
+	for _, cd := range cdefs {
+
+		// rewrite dependencies on replicated definitions
+		var dependsOn []Dependency
+		for _, dep := range cd.DependsOn {
+			names, ok := expandedNames[dep.Name]
+			if !ok {
+				dependsOn = append(dependsOn, dep)
+				continue
+			}
+			for _, name := range names {
+				dependsOn = append(dependsOn, Dependency{Name: name, Condition: dep.Condition})
+			}
+		}
+		cd.DependsOn = dependsOn
+
+		if cd.Replicas == 0 {
+			expanded = append(expanded, cd)
+			continue
+		}
+
+		for i := 1; i <= cd.Replicas; i++ {
+			replica := *cd
+			replica.DefinitionName = cd.ContainerName
+			replica.ContainerName = replicaName(cd.ContainerName, i)
+			replica.ReplicaIndex = i
+
+			// variables set in the definition take precedence over those set
+			// by oneill, so the index goes first
+			replica.Env = append([]string{fmt.Sprintf("%s=%d", EnvReplicaIndex, i)}, cd.Env...)
+
+			expanded = append(expanded, &replica)
+		}
+	}
+
+	return expanded
+}
@@ -0,0 +1,85 @@
+package containerdefs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// minimum limits accepted by docker
+const (
+	minMemory   = 6 << 20
+	minCPUQuota = 1000
+)
+
+var rxCpuset = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+
+// resources converts this definition's resource limits into the form docker
+// expects, returning an error if any of them are invalid.
+func (cd *ContainerDefinition) resources() (dockerclient.Resources, error) {
+
+	var r dockerclient.Resources
+	var err error
+
+	if cd.Memory != "" {
+		if r.Memory, err = dockerclient.ParseBytes(cd.Memory); err != nil {
+			return r, fmt.Errorf("memory: %s", err)
+		}
+		if r.Memory < minMemory {
+			return r, errors.New("memory must be at least 6m")
+		}
+	}
+
+	if cd.MemorySwap == "-1" {
+		r.MemorySwap = -1
+	} else if cd.MemorySwap != "" {
+		if r.MemorySwap, err = dockerclient.ParseBytes(cd.MemorySwap); err != nil {
+			return r, fmt.Errorf("memory_swap: %s", err)
+		}
+		if r.MemorySwap < r.Memory {
+			return r, errors.New("memory_swap must be at least as large as memory")
+		}
+	}
+	if r.MemorySwap != 0 && r.Memory == 0 {
+		return r, errors.New("memory_swap can only be used with memory")
+	}
+
+	if cd.CPUShares < 0 || cd.CPUShares == 1 {
+		return r, errors.New("cpu_shares must be at least 2")
+	}
+	r.CPUShares = cd.CPUShares
+
+	if cd.CPUs != "" {
+		cpus, err := strconv.ParseFloat(cd.CPUs, 64)
+		if err != nil || cpus <= 0 {
+			return r, fmt.Errorf("not a valid value for cpus: %s", cd.CPUs)
+		}
+		r.NanoCPUs = int64(cpus * 1e9)
+	}
+
+	if cd.CPUQuota != 0 {
+		if cd.CPUQuota < minCPUQuota {
+			return r, errors.New("cpu_quota must be at least 1000 (1ms)")
+		}
+		if r.NanoCPUs != 0 {
+			return r, errors.New("cpus and cpu_quota can't be used together")
+		}
+		r.CPUQuota = cd.CPUQuota
+		r.CPUPeriod = dockerclient.CPUPeriod
+	}
+
+	if cd.Cpuset != "" && !rxCpuset.MatchString(cd.Cpuset) {
+		return r, fmt.Errorf("not a valid value for cpuset: %s", cd.Cpuset)
+	}
+	r.CpusetCpus = cd.Cpuset
+
+	if cd.PidsLimit < -1 {
+		return r, errors.New("pids_limit must be -1 (unlimited) or more")
+	}
+	r.PidsLimit = cd.PidsLimit
+
+	return r, nil
+}
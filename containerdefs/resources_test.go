@@ -0,0 +1,71 @@
+package containerdefs
+
+import (
+	"testing"
+
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+func TestResources(t *testing.T) {
+
+	tests := []struct {
+		cd       ContainerDefinition
+		expected dockerclient.Resources
+	}{
+		{ContainerDefinition{}, dockerclient.Resources{}},
+		{ContainerDefinition{Memory: "512m"}, dockerclient.Resources{Memory: 512 << 20}},
+		{ContainerDefinition{Memory: "6m"}, dockerclient.Resources{Memory: 6 << 20}},
+		{ContainerDefinition{Memory: "512m", MemorySwap: "1g"}, dockerclient.Resources{Memory: 512 << 20, MemorySwap: 1 << 30}},
+		{ContainerDefinition{Memory: "512m", MemorySwap: "512m"}, dockerclient.Resources{Memory: 512 << 20, MemorySwap: 512 << 20}},
+		{ContainerDefinition{Memory: "512m", MemorySwap: "-1"}, dockerclient.Resources{Memory: 512 << 20, MemorySwap: -1}},
+		{ContainerDefinition{CPUShares: 512}, dockerclient.Resources{CPUShares: 512}},
+		{ContainerDefinition{CPUs: "1.5"}, dockerclient.Resources{NanoCPUs: 1500000000}},
+		{ContainerDefinition{CPUs: "0.25"}, dockerclient.Resources{NanoCPUs: 250000000}},
+		{ContainerDefinition{CPUQuota: 50000}, dockerclient.Resources{CPUQuota: 50000, CPUPeriod: dockerclient.CPUPeriod}},
+		{ContainerDefinition{Cpuset: "0"}, dockerclient.Resources{CpusetCpus: "0"}},
+		{ContainerDefinition{Cpuset: "0-3,6"}, dockerclient.Resources{CpusetCpus: "0-3,6"}},
+		{ContainerDefinition{PidsLimit: -1}, dockerclient.Resources{PidsLimit: -1}},
+		{ContainerDefinition{PidsLimit: 100}, dockerclient.Resources{PidsLimit: 100}},
+	}
+
+	for i, test := range tests {
+		r, err := test.cd.resources()
+		if err != nil {
+			t.Errorf("Unexpected error for definition %d: %s", i, err)
+			continue
+		}
+		if r != test.expected {
+			t.Errorf("Expected resources for definition %d to be %+v, got %+v", i, test.expected, r)
+		}
+	}
+}
+
+func TestResourcesErrors(t *testing.T) {
+
+	definitions := []ContainerDefinition{
+		{Memory: "lots"},
+		{Memory: "5m"},
+		{Memory: "512m", MemorySwap: "256m"},
+		{Memory: "512m", MemorySwap: "lots"},
+		{MemorySwap: "1g"},
+		{MemorySwap: "-1"},
+		{CPUShares: 1},
+		{CPUShares: -2},
+		{CPUs: "0"},
+		{CPUs: "-1"},
+		{CPUs: "two"},
+		{CPUQuota: 999},
+		{CPUQuota: -1},
+		{CPUs: "1", CPUQuota: 50000},
+		{Cpuset: "0-"},
+		{Cpuset: "0,,1"},
+		{Cpuset: "all"},
+		{PidsLimit: -2},
+	}
+
+	for i, cd := range definitions {
+		if _, err := cd.resources(); err == nil {
+			t.Errorf("Expected error for definition %d", i)
+		}
+	}
+}
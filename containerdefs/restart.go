@@ -0,0 +1,72 @@
+package containerdefs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+
+	"github.com/rehabstudio/oneill/config"
+)
+
+// restart policies supported by container definitions. `on-failure:<n>`
+// policies are also supported, e.g. `on-failure:5`.
+const (
+	RestartNo            = "no"
+	RestartAlways        = "always"
+	RestartUnlessStopped = "unless-stopped"
+	RestartOnFailure     = "on-failure"
+)
+
+// ParseRestartPolicy converts a restart policy in the form used by `docker
+// run --restart` into the form docker's API expects. `on-failure` can
+// optionally be limited to a maximum number of restarts, e.g.
+// `on-failure:5`.
+func ParseRestartPolicy(policy string) (docker.RestartPolicy, error) {
+
+	switch policy {
+	case RestartNo, RestartAlways, RestartUnlessStopped, RestartOnFailure:
+		return docker.RestartPolicy{Name: policy}, nil
+	}
+
+	if strings.HasPrefix(policy, RestartOnFailure+":") {
+		retries, err := strconv.Atoi(strings.TrimPrefix(policy, RestartOnFailure+":"))
+		if err == nil && retries >= 0 {
+			return docker.RestartOnFailure(retries), nil
+		}
+	}
+
+	return docker.RestartPolicy{}, fmt.Errorf("not a valid restart policy: %s", policy)
+}
+
+// restartPolicy returns the restart policy used for this definition's
+// containers, falling back to the configured default. Jobs are run to
+// completion exactly once, so they're never restarted. Both policies are
+// validated when they're loaded.
+func (cd *ContainerDefinition) restartPolicy(conf *config.Configuration) docker.RestartPolicy {
+
+	if cd.IsJob() || cd.IsScheduled() {
+		return docker.NeverRestart()
+	}
+
+	policy := cd.RestartPolicy
+	if policy == "" {
+		policy = conf.RestartPolicy
+	}
+	restartPolicy, _ := ParseRestartPolicy(policy)
+
+	return restartPolicy
+}
+
+// restartPoliciesMatch compares two restart policies, treating an empty name
+// as docker's default of `no`.
+func restartPoliciesMatch(a, b docker.RestartPolicy) bool {
+	if a.Name == "" {
+		a.Name = RestartNo
+	}
+	if b.Name == "" {
+		b.Name = RestartNo
+	}
+	return a == b
+}
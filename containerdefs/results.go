@@ -0,0 +1,181 @@
+package containerdefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ActionSkip is recorded for definitions that weren't processed because one
+// of their dependencies couldn't be started.
+const ActionSkip Action = "skip"
+
+// exit codes used to report the outcome of a run
+const (
+	ExitSuccess        = 0
+	ExitPartialFailure = 3
+	ExitTotalFailure   = 4
+)
+
+// Result records the outcome of processing a single container definition (or
+// removing a single redundant container).
+type Result struct {
+	ContainerName   string  `json:"container_name"`
+	Action          Action  `json:"action"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+	ImageIDBefore   string  `json:"image_id_before,omitempty"`
+	ImageIDAfter    string  `json:"image_id_after,omitempty"`
+}
+
+// Failed reports whether the action recorded in the result failed.
+func (r *Result) Failed() bool {
+	return r.Error != ""
+}
+
+// newResult initialises a result for the given container, recording the
+// time so that finish can calculate the duration.
+func newResult(containerName string) (*Result, time.Time) {
+	return &Result{ContainerName: containerName, Action: ActionNone}, time.Now()
+}
+
+// finish records the duration and (optional) error of a result.
+func (r *Result) finish(started time.Time, err error) *Result {
+	r.DurationSeconds = time.Since(started).Seconds()
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Report collects the results of every action taken during a run.
+type Report struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Results  []*Result `json:"results"`
+}
+
+// NewReport initialises an empty report for a run starting now.
+func NewReport() *Report {
+	return &Report{Started: time.Now()}
+}
+
+// Add appends results to the report.
+func (r *Report) Add(results ...*Result) {
+	r.Results = append(r.Results, results...)
+}
+
+// Finish marks the report as complete.
+func (r *Report) Finish() {
+	r.Finished = time.Now()
+}
+
+// count returns the number of results with the given action.
+func (r *Report) count(action Action) int {
+	var n int
+	for _, result := range r.Results {
+		if result.Action == action {
+			n = n + 1
+		}
+	}
+	return n
+}
+
+// failures returns the number of failed results.
+func (r *Report) failures() int {
+	var n int
+	for _, result := range r.Results {
+		if result.Failed() {
+			n = n + 1
+		}
+	}
+	return n
+}
+
+// ExitCode returns the exit code oneill should use to report the outcome of
+// the run: ExitSuccess if nothing failed, ExitTotalFailure if every action
+// failed and ExitPartialFailure otherwise.
+func (r *Report) ExitCode() int {
+	failures := r.failures()
+	switch {
+	case failures == 0:
+		return ExitSuccess
+	case failures == len(r.Results):
+		return ExitTotalFailure
+	default:
+		return ExitPartialFailure
+	}
+}
+
+// LogSummary logs a single line summarising the run.
+func (r *Report) LogSummary() {
+
+	fields := logrus.Fields{
+		"started":   r.count(ActionStart),
+		"recreated": r.count(ActionRecreate),
+		"jobs_run":  r.count(ActionRun),
+		"removed":   r.count(ActionRemove),
+		"restarted": r.count(ActionRestart),
+		"deferred":  r.count(ActionDefer),
+		"skipped":   r.count(ActionSkip),
+		"unchanged": r.count(ActionNone),
+		"failed":    r.failures(),
+		"duration":  r.Finished.Sub(r.Started).String(),
+	}
+
+	if r.failures() > 0 {
+		logrus.WithFields(fields).Error("Run completed with failures")
+	} else {
+		logrus.WithFields(fields).Info("Run completed")
+	}
+}
+
+// WriteText writes a human readable representation of the report to w.
+func (r *Report) WriteText(w io.Writer) error {
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, result := range r.Results {
+		status := "ok"
+		if result.Failed() {
+			status = "failed: " + result.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1fs\t%s\n", result.Action, result.ContainerName, result.DurationSeconds, status)
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON writes the report to w as a JSON document.
+func (r *Report) WriteJSON(w io.Writer) error {
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// WriteFile writes the report to the given path as a JSON document.
+func (r *Report) WriteFile(path string) error {
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// results can include error messages from docker and hooks, which may
+	// contain sensitive details, so the report is only readable by oneill's
+	// own user (an existing file may have been written more permissively)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
@@ -0,0 +1,277 @@
+package containerdefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/dockerclient"
+	"github.com/rehabstudio/oneill/schedule"
+)
+
+// defaultKeepFinished is the number of finished containers kept for each
+// scheduled job when a definition doesn't specify keep_finished
+const defaultKeepFinished = 3
+
+// scheduledRunFormat is used to timestamp the containers for each run of a
+// scheduled job. Timestamps in this format sort in chronological order.
+const scheduledRunFormat = "20060102T150405"
+
+// IsScheduled reports whether this definition is a job run on a schedule.
+func (cd *ContainerDefinition) IsScheduled() bool {
+	return cd.Schedule != ""
+}
+
+// keepFinished returns the number of finished containers to keep for this
+// scheduled job.
+func (cd *ContainerDefinition) keepFinished() int {
+	if cd.KeepFinished > 0 {
+		return cd.KeepFinished
+	}
+	return defaultKeepFinished
+}
+
+// ScheduledDefinitionsDue returns all scheduled definitions whose schedule
+// fires during the minute containing t.
+func ScheduledDefinitionsDue(cdefs []*ContainerDefinition, t time.Time) []*ContainerDefinition {
+
+	var due []*ContainerDefinition
+	for _, cd := range cdefs {
+		if !cd.IsScheduled() {
+			continue
+		}
+		s, err := schedule.Parse(cd.Schedule)
+		if err != nil {
+			continue
+		}
+		if s.Matches(t) {
+			due = append(due, cd)
+		}
+	}
+
+	return due
+}
+
+// isScheduledRun reports whether a container with the given labels is a run
+// of one of the given scheduled job definitions.
+func isScheduledRun(labels map[string]string, cdefs []*ContainerDefinition) bool {
+
+	if labels[LabelScheduledRun] == "" {
+		return false
+	}
+
+	for _, cd := range cdefs {
+		if cd.IsScheduled() && cd.ContainerName == labels[LabelDefinition] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scheduledRuns returns the containers for previous runs of this scheduled
+// job, newest first.
+func (cd *ContainerDefinition) scheduledRuns(conf *config.Configuration) ([]docker.APIContainers, error) {
+
+	containers, err := dockerclient.ListContainersWithLabel(LabelDefinition, cd.ContainerName)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []docker.APIContainers
+	for _, c := range containers {
+		labels, err := dockerclient.ContainerLabels(c.ID)
+		if err != nil {
+			return nil, err
+		}
+		if labels[LabelInstance] == conf.InstanceID && labels[LabelScheduledRun] != "" {
+			runs = append(runs, c)
+		}
+	}
+
+	sort.Sort(sort.Reverse(byName(runs)))
+	return runs, nil
+}
+
+// byName sorts containers by name
+type byName []docker.APIContainers
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].Names[0] < b[j].Names[0] }
+
+// scheduledRunRecord is appended to a scheduled job's history file after
+// every run.
+type scheduledRunRecord struct {
+	ContainerName   string    `json:"container_name"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	ExitCode        int       `json:"exit_code"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// recordScheduledRun appends a record of a single run to the scheduled job's
+// history file in oneill's state directory.
+func (cd *ContainerDefinition) recordScheduledRun(conf *config.Configuration, record scheduledRunRecord) error {
+
+	historyPath := path.Join(conf.StateDirectory, "schedules", cd.ContainerName+".jsonl")
+	if err := os.MkdirAll(path.Dir(historyPath), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\n", data)
+	return err
+}
+
+// pruneScheduledRuns removes finished containers for this scheduled job,
+// keeping the most recent keep_finished.
+func (cd *ContainerDefinition) pruneScheduledRuns(conf *config.Configuration) error {
+
+	runs, err := cd.scheduledRuns(conf)
+	if err != nil {
+		return err
+	}
+
+	var finished int
+	for _, c := range runs {
+		container, err := dockerclient.InspectContainer(c.ID)
+		if err != nil || container.State.Running {
+			continue
+		}
+		finished = finished + 1
+		if finished > cd.keepFinished() {
+			if err := dockerclient.RemoveContainer(c, dockerclient.ContainerStopTimeout); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// runningScheduledRun returns the name of a run of this scheduled job that
+// is still running, or an empty string if there isn't one.
+func (cd *ContainerDefinition) runningScheduledRun(conf *config.Configuration) (string, error) {
+
+	runs, err := cd.scheduledRuns(conf)
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range runs {
+		container, err := dockerclient.InspectContainer(c.ID)
+		if err == nil && container.State.Running {
+			return strings.TrimPrefix(c.Names[0], "/"), nil
+		}
+	}
+
+	return "", nil
+}
+
+// RunScheduledJob runs a single instance of a scheduled job, waiting for it
+// to complete. The container is started in exactly the same way as any
+// other container, so env, persistence and docker control all behave the
+// same. The run's exit code and duration are logged and recorded in
+// oneill's state directory, and old finished runs are pruned afterwards.
+func RunScheduledJob(conf *config.Configuration, cd *ContainerDefinition, t time.Time) *Result {
+
+	runName := fmt.Sprintf("%s-%s", cd.ContainerName, t.Format(scheduledRunFormat))
+	result, started := newResult(runName)
+
+	if !cd.AllowOverlap {
+		running, err := cd.runningScheduledRun(conf)
+		if err != nil {
+			return result.finish(started, err)
+		}
+		if running != "" {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"running":        running,
+			}).Warning("Previous run of scheduled job still running, skipping")
+			result.Action = ActionSkip
+			return result.finish(started, nil)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"container_name": runName,
+	}).Info("Running scheduled job")
+
+	result.Action = ActionRun
+
+	// scheduled jobs keep running while changes are frozen, but on whatever
+	// image is already present rather than upgrading it
+	exitCode := -1
+	var err error
+	if frozen, reason := changesFrozen(conf); !frozen {
+		err = cd.pullImage(conf)
+	} else if _, err = dockerclient.InspectImage(cd.RepoTag); err != nil {
+		err = fmt.Errorf("image %s not present locally and %s", cd.RepoTag, reason)
+	}
+	var id string
+	if err == nil {
+		opts := cd.containerOptions(conf, runName)
+		opts.Labels[LabelScheduledRun] = t.Format(scheduledRunFormat)
+		id, err = dockerclient.StartContainer(opts)
+	}
+	if err == nil {
+		exitCode, err = dockerclient.WaitContainer(id, cd.jobTimeout())
+	}
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("job exited with status %d", exitCode)
+	}
+	result.finish(started, err)
+
+	fields := logrus.Fields{
+		"container_name": runName,
+		"exit_code":      exitCode,
+		"duration":       time.Since(started).String(),
+	}
+	if err != nil {
+		fields["err"] = err
+		logrus.WithFields(fields).Error("Scheduled job failed")
+	} else {
+		logrus.WithFields(fields).Info("Scheduled job completed")
+	}
+
+	if err := cd.recordScheduledRun(conf, scheduledRunRecord{
+		ContainerName:   runName,
+		Started:         started,
+		DurationSeconds: result.DurationSeconds,
+		ExitCode:        exitCode,
+		Error:           result.Error,
+	}); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Error("Unable to record scheduled job run")
+	}
+
+	if err := cd.pruneScheduledRuns(conf); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Error("Unable to prune finished scheduled job containers")
+	}
+
+	return result
+}
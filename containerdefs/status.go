@@ -0,0 +1,112 @@
+package containerdefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// ContainerStatus describes the current state of the container for a single
+// container definition.
+type ContainerStatus struct {
+	ContainerName string `json:"container_name"`
+	RepoTag       string `json:"repo_tag"`
+	ContainerID   string `json:"container_id,omitempty"`
+	ImageID       string `json:"image_id,omitempty"`
+	RepoDigest    string `json:"repo_digest,omitempty"`
+	State         string `json:"state"`
+	Health        string `json:"health,omitempty"`
+}
+
+// Status holds the state of the containers for every loaded container
+// definition.
+type Status struct {
+	Containers []*ContainerStatus `json:"containers"`
+}
+
+// WriteText writes a human readable representation of the status to w.
+func (s *Status) WriteText(w io.Writer) error {
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tREPO TAG\tDIGEST\tCONTAINER\tIMAGE\tSTATE\tHEALTH\n")
+	for _, c := range s.Containers {
+		health := c.Health
+		if health == "" {
+			health = "-"
+		}
+		digest := shortDigest(c.RepoDigest)
+		if digest == "" {
+			digest = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.ContainerName, c.RepoTag, digest, shortID(c.ContainerID), shortID(c.ImageID), c.State, health)
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON writes the status to w as a JSON document.
+func (s *Status) WriteJSON(w io.Writer) error {
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// shortDigest truncates an image digest in the same way as shortID, keeping
+// the algorithm prefix, e.g. `sha256:0123456789ab`.
+func shortDigest(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 {
+		return digest[:i+1] + shortID(digest[i+1:])
+	}
+	return shortID(digest)
+}
+
+// BuildStatus inspects the container for each of the given container
+// definitions, reporting its current state and health.
+func BuildStatus(cdefs []*ContainerDefinition) (*Status, error) {
+
+	status := &Status{}
+	for _, cd := range cdefs {
+
+		cs := &ContainerStatus{ContainerName: cd.ContainerName, RepoTag: cd.RepoTag, State: "missing"}
+		status.Containers = append(status.Containers, cs)
+
+		if cd.IsScheduled() {
+			cs.State = fmt.Sprintf("scheduled (%s)", cd.Schedule)
+			continue
+		}
+
+		c, err := dockerclient.GetContainerByName(cd.ContainerName)
+		if err != nil {
+			continue
+		}
+		container, err := dockerclient.InspectContainer(c.ID)
+		if err != nil {
+			return status, err
+		}
+
+		cs.ContainerID = container.ID
+		cs.ImageID = container.Image
+		if labels, err := dockerclient.ContainerLabels(container.ID); err == nil {
+			cs.RepoDigest = labels[LabelRepoDigest]
+		}
+		if container.State.Running {
+			cs.State = "running"
+			if cd.HealthCheck != nil {
+				cs.Health = cd.HealthCheck.Status(container.ID, false)
+			}
+		} else {
+			cs.State = fmt.Sprintf("exited (%d)", container.State.ExitCode)
+		}
+	}
+
+	return status, nil
+}
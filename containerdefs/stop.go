@@ -0,0 +1,142 @@
+package containerdefs
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+var (
+	rxStopSignal = regexp.MustCompile(`^(SIG[A-Z0-9+-]+|[0-9]+)$`)
+)
+
+// defaultPreStopTimeout is the maximum time a pre-stop hook may take when it
+// doesn't specify its own timeout
+const defaultPreStopTimeout = 30 * time.Second
+
+// HTTPHook makes an HTTP request to the given port and path on the
+// container's IP address. Any 2xx or 3xx response is considered a success.
+type HTTPHook struct {
+	Port   int    `yaml:"port"`
+	Path   string `yaml:"path"`
+	Method string `yaml:"method"`
+}
+
+// PreStopHook is run against a container before it's sent its stop signal,
+// giving it a chance to drain connections or finish in-flight work. Exactly
+// one of HTTP or Exec should be set.
+type PreStopHook struct {
+	HTTP *HTTPHook `yaml:"http"`
+
+	// Exec is a command run inside the container
+	Exec []string `yaml:"exec"`
+
+	// Timeout is the maximum time the hook may take (default: 30s)
+	Timeout string `yaml:"timeout"`
+}
+
+func (h *PreStopHook) timeout() time.Duration {
+	return parseDurationOr(h.Timeout, defaultPreStopTimeout)
+}
+
+// validate checks that the pre-stop hook is internally consistent.
+func (h *PreStopHook) validate() error {
+
+	if (h.HTTP != nil) == (len(h.Exec) > 0) {
+		return fmt.Errorf("exactly one of http or exec must be set")
+	}
+
+	if h.HTTP != nil && (h.HTTP.Port <= 0 || h.HTTP.Port > 65535) {
+		return fmt.Errorf("not a valid http port: %d", h.HTTP.Port)
+	}
+
+	if h.Timeout != "" {
+		if _, err := time.ParseDuration(h.Timeout); err != nil {
+			return fmt.Errorf("not a valid value for timeout: %s", h.Timeout)
+		}
+	}
+
+	return nil
+}
+
+// Run runs the hook against the given container.
+func (h *PreStopHook) Run(containerID string) error {
+
+	if len(h.Exec) > 0 {
+		exitCode, err := dockerclient.ExecCommand(containerID, h.Exec, h.timeout())
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("pre-stop command exited with status %d", exitCode)
+		}
+		return nil
+	}
+
+	container, err := dockerclient.InspectContainer(containerID)
+	if err != nil {
+		return err
+	}
+	ip := container.NetworkSettings.IPAddress
+
+	method := h.HTTP.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(ip, strconv.Itoa(h.HTTP.Port)), h.HTTP.Path)
+	req, err := http.NewRequest(strings.ToUpper(method), url, nil)
+	if err != nil {
+		return err
+	}
+
+	httpClient := &http.Client{Timeout: h.timeout()}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("pre-stop request returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// stopTimeout returns how long this definition's containers are given to
+// exit after being sent their stop signal.
+func (cd *ContainerDefinition) stopTimeout() time.Duration {
+	if d, err := time.ParseDuration(cd.StopTimeout); err == nil {
+		return d
+	}
+	return dockerclient.ContainerStopTimeout
+}
+
+// removeContainer gracefully stops and removes a container started from this
+// definition. The pre-stop hook (if any) is run first, a failing hook is
+// logged but doesn't prevent the container from being stopped.
+func (cd *ContainerDefinition) removeContainer(c docker.APIContainers) error {
+
+	if cd.PreStop != nil {
+		container, err := dockerclient.InspectContainer(c.ID)
+		if err == nil && container.State.Running {
+			if err := cd.PreStop.Run(c.ID); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"container_name": strings.TrimPrefix(c.Names[0], "/"),
+					"err":            err,
+				}).Warning("Pre-stop hook failed, stopping container anyway")
+			}
+		}
+	}
+
+	return dockerclient.RemoveContainer(c, cd.stopTimeout())
+}
@@ -0,0 +1,149 @@
+package containerdefs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// update strategies supported by container definitions
+const (
+	UpdateRecreate  = "recreate"
+	UpdateBlueGreen = "blue-green"
+)
+
+// defaultReadinessDelay is used for blue-green updates when a definition
+// doesn't specify a readiness_delay
+const defaultReadinessDelay = 10 * time.Second
+
+// nextContainerSuffix is appended to a definition's container name to get the
+// temporary name used while starting a new container during a blue-green
+// update.
+const nextContainerSuffix = "-oneill-next"
+
+// readinessDelay returns how long a new container must keep running before
+// it's considered ready.
+func (cd *ContainerDefinition) readinessDelay() time.Duration {
+	if d, err := time.ParseDuration(cd.ReadinessDelay); err == nil {
+		return d
+	}
+	return defaultReadinessDelay
+}
+
+// useBlueGreen decides whether a container should be replaced using the
+// blue-green strategy. This is only possible when the existing container is
+// still running and the definition doesn't bind any host ports or mount any
+// writable persistent volumes (the old and new containers would both write
+// to the same data at once).
+func (cd *ContainerDefinition) useBlueGreen() bool {
+
+	if cd.UpdateStrategy != UpdateBlueGreen {
+		return false
+	}
+
+	if len(cd.PortMapping) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+		}).Warning("blue-green updates aren't possible with a port mapping, falling back to recreate")
+		return false
+	}
+
+	if cd.hasWritableVolumes() {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+		}).Warning("blue-green updates aren't possible with persistent volumes, falling back to recreate")
+		return false
+	}
+
+	c, err := dockerclient.GetContainerByName(cd.ContainerName)
+	if err != nil {
+		return false
+	}
+	container, err := dockerclient.InspectContainer(c.ID)
+	if err != nil {
+		return false
+	}
+
+	return container.State.Running
+}
+
+// waitUntilReady waits until the given container is considered ready,
+// returning an error if it stops or restarts before then. Containers with a
+// healthcheck are ready once they pass it, otherwise a container is ready
+// once it has been running for the definition's readiness delay.
+func (cd *ContainerDefinition) waitUntilReady(id string) error {
+
+	if cd.HealthCheck != nil {
+		return cd.HealthCheck.WaitHealthy(id)
+	}
+
+	container, err := dockerclient.InspectContainer(id)
+	if err != nil {
+		return err
+	}
+	startedAt := container.State.StartedAt
+
+	deadline := time.Now().Add(cd.readinessDelay())
+	for {
+		container, err := dockerclient.InspectContainer(id)
+		if err != nil {
+			return err
+		}
+		if !container.State.Running {
+			return fmt.Errorf("container exited with status %d", container.State.ExitCode)
+		}
+		if !container.State.StartedAt.Equal(startedAt) {
+			return fmt.Errorf("container restarted")
+		}
+		if time.Now().After(deadline) {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// blueGreenReplace starts a new container for this definition under a
+// temporary name, waits for it to become ready, then removes the old
+// container and renames the new one into place. If the new container doesn't
+// become ready it is removed and the old container is left running.
+func (cd *ContainerDefinition) blueGreenReplace(conf *config.Configuration) error {
+
+	nextName := cd.ContainerName + nextContainerSuffix
+
+	// clean up any container left behind by a previous failed update
+	if c, err := dockerclient.GetContainerByName(nextName); err == nil {
+		if err := cd.removeContainer(c); err != nil {
+			return err
+		}
+	}
+
+	id, err := cd.startContainerAs(conf, nextName)
+	if err != nil {
+		if c, err := dockerclient.GetContainerByName(nextName); err == nil {
+			cd.removeContainer(c)
+		}
+		return err
+	}
+
+	if err := cd.waitUntilReady(id); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"container_name": cd.ContainerName,
+			"err":            err,
+		}).Error("New container failed readiness check, keeping existing container")
+		if c, err := dockerclient.GetContainerByName(nextName); err == nil {
+			cd.removeContainer(c)
+		}
+		return err
+	}
+
+	// the new container is ready, swap it in for the old one
+	if err := cd.RemoveContainer(); err != nil {
+		return err
+	}
+
+	return dockerclient.RenameContainer(id, cd.ContainerName)
+}
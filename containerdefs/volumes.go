@@ -0,0 +1,193 @@
+package containerdefs
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/rehabstudio/oneill/config"
+)
+
+// types of volume
+const (
+	VolumeManaged = "managed"
+	VolumeNamed   = "volume"
+	VolumeBind    = "bind"
+)
+
+var rxVolumeName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// Volume declares a single volume mounted into a container at Target. A
+// `managed` volume (the default) is a directory managed by oneill under
+// `persistence_directory/{container_name}`, named after Source (a relative
+// path) or Target if Source isn't set. A `volume` is the docker named volume
+// Source, created if needed. A `bind` mounts the host path Source, which must
+// be under one of the prefixes in the configured bind_mount_allowlist. Any
+// volume can be mounted read only, and can be relabelled for SELinux with `z`
+// (shared between containers) or `Z` (private to this container).
+type Volume struct {
+	Type     string `yaml:"type"`
+	Source   string `yaml:"source"`
+	Target   string `yaml:"target"`
+	ReadOnly bool   `yaml:"read_only"`
+	SELinux  string `yaml:"selinux"`
+}
+
+// validate checks that a volume is internally consistent. Whether a bind
+// mount is allowed depends on oneill's configuration, so that's checked
+// separately by allowed.
+func (v *Volume) validate() error {
+
+	if !path.IsAbs(v.Target) {
+		return fmt.Errorf("volume target must be an absolute path: %s", v.Target)
+	}
+
+	switch v.Type {
+	case "", VolumeManaged:
+		if source := path.Clean(v.Source); path.IsAbs(source) || source == ".." || strings.HasPrefix(source, "../") {
+			return fmt.Errorf("managed volume source must be a relative path within the persistence directory: %s", v.Source)
+		}
+	case VolumeNamed:
+		if !rxVolumeName.MatchString(v.Source) {
+			return fmt.Errorf("not a valid volume name: %s", v.Source)
+		}
+	case VolumeBind:
+		if !path.IsAbs(v.Source) {
+			return fmt.Errorf("bind mount source must be an absolute path: %s", v.Source)
+		}
+	default:
+		return fmt.Errorf("not a valid volume type: %s", v.Type)
+	}
+
+	if v.SELinux != "" && v.SELinux != "z" && v.SELinux != "Z" {
+		return fmt.Errorf("not a valid selinux option (must be z or Z): %s", v.SELinux)
+	}
+
+	return nil
+}
+
+// allowed checks that a bind mount's source is within one of the prefixes
+// in the configured bind_mount_allowlist. Other types of volume are always
+// allowed.
+func (v *Volume) allowed(conf *config.Configuration) error {
+
+	if v.Type != VolumeBind {
+		return nil
+	}
+
+	source := path.Clean(v.Source)
+	for _, prefix := range conf.BindMountAllowlist {
+		prefix = path.Clean(prefix)
+		if source == prefix || strings.HasPrefix(source, strings.TrimSuffix(prefix, "/")+"/") {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("bind mount source not in bind_mount_allowlist: %s", v.Source)
+}
+
+// bind returns the volume in the `source:target[:options]` form docker
+// expects, resolving managed volumes to a directory under the given
+// container's persistence directory.
+func (v *Volume) bind(conf *config.Configuration, containerName string) string {
+
+	source := v.Source
+	switch v.Type {
+	case "", VolumeManaged:
+		if source == "" {
+			source = v.Target
+		}
+		source = path.Join(conf.PersistenceDirectory, containerName, source)
+	case VolumeBind:
+		source = path.Clean(source)
+	}
+
+	var options []string
+	if v.ReadOnly {
+		options = append(options, "ro")
+	}
+	if v.SELinux != "" {
+		options = append(options, v.SELinux)
+	}
+
+	bind := fmt.Sprintf("%s:%s", source, path.Clean(v.Target))
+	if len(options) > 0 {
+		bind = bind + ":" + strings.Join(options, ",")
+	}
+
+	return bind
+}
+
+// validateVolumes checks each of this definition's volumes, and that no two
+// are mounted at the same target.
+func (cd *ContainerDefinition) validateVolumes() error {
+
+	targets := make(map[string]bool)
+	for i := range cd.Volumes {
+		v := &cd.Volumes[i]
+		if err := v.validate(); err != nil {
+			return err
+		}
+		target := path.Clean(v.Target)
+		if targets[target] {
+			return fmt.Errorf("more than one volume mounted at %s", target)
+		}
+		targets[target] = true
+	}
+
+	return nil
+}
+
+// volumesAllowed checks that every host bind mount declared by this
+// definition is allowed by oneill's configuration.
+func (cd *ContainerDefinition) volumesAllowed(conf *config.Configuration) error {
+
+	for i := range cd.Volumes {
+		if err := cd.Volumes[i].allowed(conf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// volumeBinds returns the binds for each of this definition's volumes.
+func (cd *ContainerDefinition) volumeBinds(conf *config.Configuration) []string {
+
+	var binds []string
+	for i := range cd.Volumes {
+		binds = append(binds, cd.Volumes[i].bind(conf, cd.ContainerName))
+	}
+
+	return binds
+}
+
+// volumeTargets returns the paths each of this definition's volumes are
+// mounted at within the container.
+func (cd *ContainerDefinition) volumeTargets() []string {
+
+	var targets []string
+	for i := range cd.Volumes {
+		targets = append(targets, path.Clean(cd.Volumes[i].Target))
+	}
+
+	return targets
+}
+
+// hasWritableVolumes reports whether this definition's containers mount any
+// persistent data that they can write to, either because persistence is
+// enabled or because a declared volume isn't read only.
+func (cd *ContainerDefinition) hasWritableVolumes() bool {
+
+	if cd.PersistenceEnabled {
+		return true
+	}
+	for i := range cd.Volumes {
+		if !cd.Volumes[i].ReadOnly {
+			return true
+		}
+	}
+
+	return false
+}
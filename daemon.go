@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/containerdefs"
+)
+
+// daemonTimings parses the reconcile interval and maximum jitter from the
+// given configuration. The interval must be positive, otherwise the daemon
+// would reconcile in a tight loop, and the jitter can't be negative.
+func daemonTimings(config *config.Configuration) (time.Duration, time.Duration, error) {
+
+	interval, err := time.ParseDuration(config.DaemonInterval)
+	if err != nil {
+		return 0, 0, err
+	}
+	if interval <= 0 {
+		return 0, 0, fmt.Errorf("daemon_interval must be greater than zero: %s", config.DaemonInterval)
+	}
+
+	jitter, err := time.ParseDuration(config.DaemonJitter)
+	if err != nil {
+		return 0, 0, err
+	}
+	if jitter < 0 {
+		return 0, 0, fmt.Errorf("daemon_jitter can't be negative: %s", config.DaemonJitter)
+	}
+
+	return interval, jitter, nil
+}
+
+// checkDaemonSource returns an error if container definitions are loaded from
+// a source that can only be read once. Stdin is used up by the first cycle,
+// every later cycle would load no definitions and try to remove everything.
+func checkDaemonSource(config *config.Configuration) error {
+	if uri, err := url.Parse(config.DefinitionsURI); err == nil && uri.Scheme == "stdin" {
+		return fmt.Errorf("definitions can't be loaded from stdin when running as a daemon")
+	}
+	return nil
+}
+
+// nextMinute returns the time to wait until the start of the next minute,
+// when the scheduled jobs due to run in that minute are started.
+func nextMinute(now time.Time) time.Duration {
+	return now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+}
+
+// nextCycle returns the time to wait before the next reconcile cycle, adding
+// a random delay of up to jitter to the configured interval.
+func nextCycle(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(int64(jitter)))
+}
+
+// daemon runs oneill as a long running process, reconciling the containers
+// running on the host with the container definitions on a regular interval.
+// A failed cycle is logged and retried on the next interval rather than
+// causing oneill to exit. Between cycles, docker events are watched so that
+// containers which die, are destroyed or are started by hand are dealt with
+// straight away. SIGHUP reloads the configuration file and triggers an
+// immediate cycle, SIGINT and SIGTERM stop the daemon once any in-progress
+// cycle has completed. Scheduled jobs are started at the start of each minute
+// their schedule matches, using the definitions loaded in the last cycle that
+// loaded them successfully. The removal limits always apply, `-force` can't
+// be used with the daemon.
+func daemon(configFilePath string) {
+
+	l := lock()
+	defer l.Close()
+
+	config := initialise(configFilePath)
+	exitOnError(checkDaemonSource(config), "Unable to run oneill as a daemon")
+	interval, jitter, err := daemonTimings(config)
+	exitOnError(err, "Unable to parse daemon interval")
+
+	rand.Seed(time.Now().UnixNano())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	// definitions loaded during the last full cycle, used for targeted
+	// reconciles triggered by docker events. If loading fails we don't
+	// perform targeted reconciles at all until a full cycle succeeds.
+	var definitions []*containerdefs.ContainerDefinition
+	var invalid []string
+	watcher := newEventWatcher()
+
+	// scheduled jobs are started by a separate goroutine, using the
+	// definitions from the last cycle that loaded them successfully
+	jobs := &scheduler{}
+	stopJobs := make(chan struct{})
+	defer close(stopJobs)
+	go jobs.run(stopJobs)
+
+	cycle := time.NewTimer(0)
+	for {
+		select {
+		case <-cycle.C:
+			logrus.Debug("Starting reconcile cycle")
+			var report *containerdefs.Report
+			definitions, invalid, report, err = reconcile(config, false)
+			if definitions != nil {
+				jobs.update(config, definitions)
+			}
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"err": err}).Error("Reconcile cycle failed")
+			} else {
+				finishReport(config, report)
+			}
+			watcher.subscribe()
+			watcher.refresh()
+
+			wait := nextCycle(interval, jitter)
+			logrus.WithFields(logrus.Fields{"next_cycle": wait.String()}).Debug("Reconcile cycle complete")
+			cycle.Reset(wait)
+
+		case event := <-watcher.events:
+			watcher.record(event)
+
+		case <-watcher.debounce:
+			names := watcher.flush()
+			if definitions == nil {
+				logrus.WithFields(logrus.Fields{"containers": names}).Warning("No container definitions loaded, skipping targeted reconcile")
+				continue
+			}
+			logrus.WithFields(logrus.Fields{"containers": names}).Info("Starting targeted reconcile")
+			report := containerdefs.NewReport()
+			results, err := containerdefs.RemoveRedundantContainersByName(config, definitions, invalid, false, names)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"err": err}).Error("Targeted reconcile failed")
+			}
+			report.Add(results...)
+			report.Add(containerdefs.ProcessContainerDefinitionsByName(config, definitions, names)...)
+			report.Finish()
+			report.LogSummary()
+
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				logrus.WithFields(logrus.Fields{"signal": sig.String()}).Info("Shutting down")
+				watcher.unsubscribe()
+				return
+			}
+
+			// reload configuration, keeping the existing configuration if
+			// the new one is invalid in any way. The event listener belongs
+			// to the docker client so we need to resubscribe afterwards.
+			logrus.Info("Reloading configuration")
+			watcher.unsubscribe()
+			newConfig, err := loadConfiguration(configFilePath)
+			if err == nil {
+				err = checkDaemonSource(newConfig)
+			}
+			if err == nil {
+				var newInterval, newJitter time.Duration
+				newInterval, newJitter, err = daemonTimings(newConfig)
+				if err == nil {
+					config, interval, jitter = newConfig, newInterval, newJitter
+				}
+			}
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"err": err}).Error("Unable to reload configuration, continuing with previous configuration")
+			}
+			cycle.Reset(0)
+		}
+	}
+}
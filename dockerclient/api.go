@@ -0,0 +1,132 @@
+package dockerclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// The vendored go-dockerclient library predates a number of docker API
+// features that oneill relies on (container labels, renaming containers,
+// etc.). apiRequest provides a minimal way of calling those endpoints
+// directly, using the same endpoint as the main client.
+
+// parseAPIEndpoint validates the docker API endpoint, converting `tcp://`
+// endpoints into their `http://` equivalent.
+func parseAPIEndpoint(endpoint string) (*url.URL, error) {
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "unix", "http", "https":
+	case "tcp":
+		u.Scheme = "http"
+	default:
+		return nil, fmt.Errorf("Unsupported docker API endpoint: %s", endpoint)
+	}
+
+	return u, nil
+}
+
+// newAPITransport returns the transport used for every request to the given
+// endpoint. A single transport is shared by all requests so that keep-alive
+// connections are reused rather than leaked. Requests to a unix socket are
+// made over http to a dummy host, the transport ensures the connection is
+// made to the socket instead.
+func newAPITransport(u *url.URL) http.RoundTripper {
+
+	if u.Scheme != "unix" {
+		return http.DefaultTransport
+	}
+
+	socketPath := u.Path
+	return &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socketPath)
+		},
+	}
+}
+
+// closeAPITransport closes any idle connections held by a transport that's
+// no longer in use.
+func closeAPITransport(transport http.RoundTripper) {
+	if t, ok := transport.(*http.Transport); ok && t != http.DefaultTransport {
+		t.CloseIdleConnections()
+	}
+}
+
+// newAPIRequest prepares a request to the docker API along with an HTTP
+// client able to send it. A timeout of zero means no timeout.
+func newAPIRequest(method, path string, body io.Reader, timeout time.Duration) (*http.Client, *http.Request, error) {
+
+	// the client is cheap to create, its connections belong to the shared
+	// transport
+	state := current()
+	httpClient := &http.Client{Transport: state.transport, Timeout: timeout}
+	requestURL := state.endpointURL.String() + path
+	if state.endpointURL.Scheme == "unix" {
+		requestURL = "http://docker" + path
+	}
+
+	req, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return httpClient, req, nil
+}
+
+// apiRequest sends a single request to the docker API. If data is not nil it
+// is serialised to JSON and sent as the request body, the response body is
+// decoded into result (if not nil). Error responses are returned as a
+// *docker.Error so that callers can inspect the status code.
+func apiRequest(method, path string, data interface{}, result interface{}) error {
+
+	var body io.Reader
+	if data != nil {
+		buf, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	httpClient, req, err := newAPIRequest(method, path, body, 0)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return &docker.Error{Status: resp.StatusCode, Message: string(respBody)}
+	}
+
+	if result != nil && len(respBody) > 0 {
+		return json.Unmarshal(respBody, result)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package dockerclient
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Command represents a command (or entrypoint) as the list of arguments
+// docker expects. In YAML it can be given either as a list or as a single
+// string, which is split into arguments the way a shell would (honouring
+// quotes and backslash escapes, but without any expansion).
+type Command []string
+
+// UnmarshalYAML converts either a YAML string or list into a Command.
+func (c *Command) UnmarshalYAML(unmarshal func(v interface{}) error) error {
+	if c == nil {
+		return errors.New("Command: UnmarshalYAML on nil pointer")
+	}
+
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*c = list
+		return nil
+	}
+
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return errors.New("command must be a string or a list of strings")
+	}
+
+	args, err := splitCommand(s)
+	if err != nil {
+		return err
+	}
+	*c = args
+
+	return nil
+}
+
+// splitCommand splits a command string into arguments on unquoted
+// whitespace. Single quotes preserve everything up to the closing quote,
+// double quotes and backslashes work as they do in a POSIX shell.
+func splitCommand(s string) ([]string, error) {
+
+	var args []string
+	var arg []rune
+	var inArg, escaped bool
+	var quote rune
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			// within double quotes a backslash only escapes characters
+			// that would otherwise be special, and is kept before any other
+			if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+				arg = append(arg, '\\')
+			}
+			arg = append(arg, r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg = append(arg, r)
+			}
+		case r == '\\':
+			escaped, inArg = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg = append(arg, r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, string(arg))
+				arg, inArg = nil, false
+			}
+		default:
+			arg, inArg = append(arg, r), true
+		}
+	}
+
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in command: %s", s)
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+
+	return args, nil
+}
+
+// ProcessDifferences compares the process a running container was started
+// with (its entrypoint, command, working directory and user) with the one
+// expected from the given options, returning a list of the settings that
+// differ. Anything not set in the options is expected to come from the image,
+// other than the image's command, which docker ignores when the entrypoint
+// is overridden.
+func ProcessDifferences(opts ContainerOptions, running, image *docker.Config) []string {
+
+	entrypoint, cmd := image.Entrypoint, image.Cmd
+	if len(opts.Entrypoint) > 0 {
+		entrypoint, cmd = opts.Entrypoint, nil
+	}
+	if len(opts.Cmd) > 0 {
+		cmd = opts.Cmd
+	}
+	workingDir, user := image.WorkingDir, image.User
+	if opts.WorkingDir != "" {
+		workingDir = opts.WorkingDir
+	}
+	if opts.User != "" {
+		user = opts.User
+	}
+
+	var differences []string
+	if !argsEqual(entrypoint, running.Entrypoint) {
+		differences = append(differences, "entrypoint")
+	}
+	if !argsEqual(cmd, running.Cmd) {
+		differences = append(differences, "command")
+	}
+	if workingDir != running.WorkingDir {
+		differences = append(differences, "working_dir")
+	}
+	if user != running.User {
+		differences = append(differences, "user")
+	}
+
+	return differences
+}
+
+// argsEqual compares two argument lists, treating nil and empty as equal.
+func argsEqual(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
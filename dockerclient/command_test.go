@@ -0,0 +1,72 @@
+package dockerclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+
+	tests := []struct {
+		command  string
+		expected []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"nginx", []string{"nginx"}},
+		{"nginx -g 'daemon off;'", []string{"nginx", "-g", "daemon off;"}},
+		{"  a \t b\nc  ", []string{"a", "b", "c"}},
+
+		// quotes
+		{`echo "hello world"`, []string{"echo", "hello world"}},
+		{`echo 'hello world'`, []string{"echo", "hello world"}},
+		{`echo ""`, []string{"echo", ""}},
+		{`echo ''`, []string{"echo", ""}},
+		{`echo "it's"`, []string{"echo", "it's"}},
+		{`echo '"quoted"'`, []string{"echo", `"quoted"`}},
+		{`echo foo"bar baz"qux`, []string{"echo", "foobar bazqux"}},
+
+		// escapes
+		{`echo hello\ world`, []string{"echo", "hello world"}},
+		{`echo \"`, []string{"echo", `"`}},
+		{`echo \\`, []string{"echo", `\`}},
+		{`echo \a`, []string{"echo", "a"}},
+		{`echo "a \"b\" c"`, []string{"echo", `a "b" c`}},
+		{`echo "a\\b"`, []string{"echo", `a\b`}},
+		{`echo "\$HOME"`, []string{"echo", "$HOME"}},
+		{`echo "a\b"`, []string{"echo", `a\b`}},
+		{`echo 'a\b'`, []string{"echo", `a\b`}},
+		{`echo 'a\'`, []string{"echo", `a\`}},
+
+		// no expansion is done
+		{`echo $HOME *`, []string{"echo", "$HOME", "*"}},
+	}
+
+	for _, test := range tests {
+		args, err := splitCommand(test.command)
+		if err != nil {
+			t.Errorf("Unexpected error splitting %q: %s", test.command, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("Expected %q to split into %q, got %q", test.command, test.expected, args)
+		}
+	}
+}
+
+func TestSplitCommandErrors(t *testing.T) {
+
+	commands := []string{
+		`echo "hello`,
+		`echo 'hello`,
+		`echo "it's`,
+		`echo hello\`,
+		`echo "hello\"`,
+	}
+
+	for _, command := range commands {
+		if _, err := splitCommand(command); err == nil {
+			t.Errorf("Expected error splitting %q", command)
+		}
+	}
+}
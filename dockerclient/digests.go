@@ -0,0 +1,76 @@
+package dockerclient
+
+import (
+	"strings"
+)
+
+// ParseImageReference splits an image reference into its repository, tag
+// and digest, any of which (other than the repository) may be empty, e.g.
+// `registry.example.com:5000/app:1.2@sha256:abc...` is split into
+// `registry.example.com:5000/app`, `1.2` and `sha256:abc...`.
+func ParseImageReference(ref string) (repository, tag, digest string) {
+
+	repository = ref
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, digest = repository[:i], repository[i+1:]
+	}
+
+	// a colon after the last slash separates the tag, any other colon is
+	// part of a registry's host:port
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+
+	return repository, tag, digest
+}
+
+// imageRepoDigests returns the repo digests (`repository@sha256:...`) of a
+// local image. The vendored docker.Image doesn't include them so they're
+// fetched directly from the API.
+func imageRepoDigests(ref string) ([]string, error) {
+
+	var image struct {
+		RepoDigests []string
+	}
+	if err := apiRequest("GET", "/images/"+ref+"/json", nil, &image); err != nil {
+		return nil, err
+	}
+
+	return image.RepoDigests, nil
+}
+
+// ResolveRepoDigest returns the content digest (`sha256:...`) of the local
+// image for the given reference. References that are already pinned to a
+// digest resolve to that digest, tags resolve to the digest the image was
+// pulled with. An empty string is returned for images that have never been
+// pushed to or pulled from a registry.
+func ResolveRepoDigest(ref string) (string, error) {
+
+	repository, _, digest := ParseImageReference(ref)
+	if digest != "" {
+		return digest, nil
+	}
+
+	repoDigests, err := imageRepoDigests(ref)
+	if err != nil {
+		return "", err
+	}
+
+	// prefer the digest from the same repository, an image can be tagged
+	// into (and pulled from) several
+	var resolved string
+	for _, repoDigest := range repoDigests {
+		parts := strings.SplitN(repoDigest, "@", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if parts[0] == repository {
+			return parts[1], nil
+		}
+		if resolved == "" {
+			resolved = parts[1]
+		}
+	}
+
+	return resolved, nil
+}
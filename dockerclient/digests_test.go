@@ -0,0 +1,35 @@
+package dockerclient
+
+import "testing"
+
+func TestParseImageReference(t *testing.T) {
+
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		ref                     string
+		repository, tag, digest string
+	}{
+		{"nginx", "nginx", "", ""},
+		{"nginx:1.9", "nginx", "1.9", ""},
+		{"nginx:latest", "nginx", "latest", ""},
+		{"library/nginx:1.9", "library/nginx", "1.9", ""},
+		{"nginx@" + digest, "nginx", "", digest},
+		{"nginx:1.9@" + digest, "nginx", "1.9", digest},
+		{"registry.example.com/app", "registry.example.com/app", "", ""},
+		{"registry.example.com/team/app:2.0", "registry.example.com/team/app", "2.0", ""},
+		{"registry.example.com:5000/app", "registry.example.com:5000/app", "", ""},
+		{"registry.example.com:5000/app:1.2", "registry.example.com:5000/app", "1.2", ""},
+		{"registry.example.com:5000/app@" + digest, "registry.example.com:5000/app", "", digest},
+		{"registry.example.com:5000/app:1.2@" + digest, "registry.example.com:5000/app", "1.2", digest},
+		{"localhost:5000/app", "localhost:5000/app", "", ""},
+	}
+
+	for _, test := range tests {
+		repository, tag, digest := ParseImageReference(test.ref)
+		if repository != test.repository || tag != test.tag || digest != test.digest {
+			t.Errorf("Expected %q to parse as (%q, %q, %q), got (%q, %q, %q)",
+				test.ref, test.repository, test.tag, test.digest, repository, tag, digest)
+		}
+	}
+}
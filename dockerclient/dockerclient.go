@@ -2,8 +2,13 @@ package dockerclient
 
 import (
 	"fmt"
+	"math"
+	"net/http"
+	"net/url"
 	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/fsouza/go-dockerclient"
@@ -11,34 +16,91 @@ import (
 	"github.com/rehabstudio/oneill/config"
 )
 
-var (
+// clientState holds everything configured by InitDockerClient. It's
+// replaced as a whole when the client is re-initialised (e.g. on SIGHUP)
+// while other goroutines, such as scheduled jobs, may still be using it.
+type clientState struct {
 	client      *docker.Client
 	credentials map[string]docker.AuthConfiguration
+	endpointURL *url.URL
+	transport   http.RoundTripper
+	ignoreRules []config.IgnoreRule
+}
+
+var (
+	stateMu sync.RWMutex
+	state   = &clientState{}
 )
 
-func InitDockerClient(endpoint string, registryCredentials map[string]config.RegistryCredentials) error {
+// current returns the client state configured by the last successful call
+// to InitDockerClient.
+func current() *clientState {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+	return state
+}
+
+func InitDockerClient(endpoint string, registryCredentials map[string]config.RegistryCredentials, ignore []config.IgnoreRule) error {
 
-	// connect to the docker daemon and initialise a new API client
-	var err error
-	client, err = docker.NewClient(endpoint)
+	// connect to the docker daemon and initialise a new API client. The
+	// package level client is only replaced on success so that a failed
+	// re-initialisation leaves the previous client usable.
+	newClient, err := docker.NewClient(endpoint)
+	if err != nil {
+		return err
+	}
+	newEndpointURL, err := parseAPIEndpoint(endpoint)
 	if err != nil {
 		return err
 	}
 
 	// initialise a docker.AuthConfiguration struct for each set of registry credentials
-	credentials = make(map[string]docker.AuthConfiguration)
+	newCredentials := make(map[string]docker.AuthConfiguration)
 	for key, value := range registryCredentials {
-		credentials[key] = docker.AuthConfiguration{
+		newCredentials[key] = docker.AuthConfiguration{
 			Username: value.Username,
 			Password: value.Password,
 		}
 	}
 
+	if err := validateIgnoreRules(ignore); err != nil {
+		return err
+	}
+
+	newState := &clientState{
+		client:      newClient,
+		credentials: newCredentials,
+		endpointURL: newEndpointURL,
+		transport:   newAPITransport(newEndpointURL),
+		ignoreRules: ignore,
+	}
+
+	stateMu.Lock()
+	oldState := state
+	state = newState
+	stateMu.Unlock()
+
+	if oldState.transport != nil {
+		closeAPITransport(oldState.transport)
+	}
+
 	return nil
 }
 
+// AddEventListener is a simple proxy function that exposes the method of the
+// same name from the instantiated docker client instance.
+func AddEventListener(listener chan *docker.APIEvents) error {
+	return current().client.AddEventListener(listener)
+}
+
+// RemoveEventListener is a simple proxy function that exposes the method of
+// the same name from the instantiated docker client instance.
+func RemoveEventListener(listener chan *docker.APIEvents) error {
+	return current().client.RemoveEventListener(listener)
+}
+
 // GetContainerByName searches for an existing container by name, returning an
-// error if not found.
+// error if not found. Ignored containers are never returned.
 func GetContainerByName(name string) (docker.APIContainers, error) {
 
 	// list all existing containers
@@ -49,7 +111,7 @@ func GetContainerByName(name string) (docker.APIContainers, error) {
 
 	// check all containers to see if one matching our name is present
 	for _, c := range containers {
-		if strings.TrimPrefix(c.Names[0], "/") == name {
+		if strings.TrimPrefix(c.Names[0], "/") == name && !Ignored(c) {
 			return c, nil
 		}
 	}
@@ -60,57 +122,162 @@ func GetContainerByName(name string) (docker.APIContainers, error) {
 // InspectContainer is a simple proxy function that exposes the method of the
 // same name from the instantiated docker client instance.
 func InspectContainer(s string) (*docker.Container, error) {
-	return client.InspectContainer(s)
+	return current().client.InspectContainer(s)
 }
 
 // InspectImage is a simple proxy function that exposes the method of the same
 // name from the instantiated docker client instance.
 func InspectImage(s string) (*docker.Image, error) {
-	return client.InspectImage(s)
+	return current().client.InspectImage(s)
+}
+
+// ContainerLabels returns the labels applied to an existing container.
+func ContainerLabels(id string) (map[string]string, error) {
+
+	var container struct {
+		Config struct {
+			Labels map[string]string
+		}
+	}
+	if err := apiRequest("GET", "/containers/"+id+"/json", nil, &container); err != nil {
+		return nil, err
+	}
+
+	return container.Config.Labels, nil
+}
+
+// ListContainersWithLabel returns a slice containing all existing docker
+// containers on the current host (running or otherwise) that have been
+// labelled with the given key and value.
+func ListContainersWithLabel(key, value string) ([]docker.APIContainers, error) {
+	return current().client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": []string{fmt.Sprintf("%s=%s", key, value)}},
+	})
 }
 
 // ListContainers returns a slice containing all existing docker containers on
 // the current host (running or otherwise).
 func ListContainers() ([]docker.APIContainers, error) {
-	return client.ListContainers(docker.ListContainersOptions{All: true})
+	return current().client.ListContainers(docker.ListContainersOptions{All: true})
+}
+
+// RemoveImage removes a single local image. Images used by a container, or
+// tagged into several repositories, aren't removed.
+func RemoveImage(id string) error {
+	return current().client.RemoveImage(id)
+}
+
+// WaitContainer blocks until the given container stops, returning its exit
+// code. If the container is still running after timeout it's killed and an
+// error is returned. A timeout of zero means wait forever.
+func WaitContainer(id string, timeout time.Duration) (int, error) {
+
+	type exit struct {
+		code int
+		err  error
+	}
+	exited := make(chan exit, 1)
+	go func() {
+		code, err := current().client.WaitContainer(id)
+		exited <- exit{code, err}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case e := <-exited:
+		return e.code, e.err
+	case <-expired:
+		logrus.WithFields(logrus.Fields{
+			"container_id": id,
+			"timeout":      timeout.String(),
+		}).Warning("Timed out waiting for docker container, killing it")
+		if err := current().client.KillContainer(docker.KillContainerOptions{ID: id}); err != nil {
+			return -1, fmt.Errorf("timed out after %s and unable to kill container: %s", timeout, err)
+		}
+		return -1, fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// RenameContainer gives an existing container a new name.
+func RenameContainer(id, name string) error {
+
+	logrus.WithFields(logrus.Fields{
+		"container_id":   id,
+		"container_name": name,
+	}).Debug("Renaming docker container")
+
+	return apiRequest("POST", "/containers/"+id+"/rename?name="+url.QueryEscape(name), nil, nil)
 }
 
-// PullImage pulls the latest image for the given repotag from a remote
-// registry. Credentials (if provided) are used in all requests to private
-// registries.
-func PullImage(repoTag string) error {
+// ContainerStopTimeout can be passed to StopContainer or RemoveContainer to
+// use the stop timeout the container was created with (or docker's default
+// of 10 seconds if it wasn't created with one).
+const ContainerStopTimeout time.Duration = -1
+
+// StopContainer gracefully stops a running container. Docker sends the
+// container's stop signal (SIGTERM unless it was created with another), then
+// waits up to timeout for the container to exit before killing it.
+func StopContainer(id string, timeout time.Duration) error {
 
 	logrus.WithFields(logrus.Fields{
-		"repo_tag": repoTag,
-	}).Debug("Pulling latest image from registry")
+		"container_id": id,
+		"timeout":      timeout.String(),
+	}).Debug("Stopping docker container")
+
+	stopPath := "/containers/" + id + "/stop"
+	if timeout >= 0 {
+		stopPath = fmt.Sprintf("%s?t=%d", stopPath, timeoutSeconds(timeout))
+	}
+
+	// docker responds with 304 if the container has already stopped
+	return apiRequest("POST", stopPath, nil, nil)
+}
+
+// timeoutSeconds converts a stop timeout into the whole number of seconds the
+// docker API expects, rounding up so that a sub-second timeout never becomes
+// an immediate kill.
+func timeoutSeconds(timeout time.Duration) int {
+	return int(math.Ceil(timeout.Seconds()))
+}
 
-	// configuration options that get passed to client.PullImage
-	repository, tag := docker.ParseRepositoryTag(repoTag)
-	pullImageOptions := docker.PullImageOptions{Repository: repository, Tag: tag}
+// RestartContainer restarts an existing container (or starts it if it has
+// stopped) without changing it, giving it up to timeout to stop gracefully.
+func RestartContainer(id string, timeout time.Duration) error {
 
-	// parse registry name from repository string
-	var registrystr string
-	parts := strings.Split(repository, "/")
-	if len(parts) <= 1 {
-		registrystr = ""
+	logrus.WithFields(logrus.Fields{
+		"container_id": id,
+	}).Debug("Restarting docker container")
+
+	restartPath := "/containers/" + id + "/restart"
+	if timeout >= 0 {
+		restartPath = fmt.Sprintf("%s?t=%d", restartPath, timeoutSeconds(timeout))
 	}
-	registrystr = parts[0]
 
-	// pull image from registry
-	return client.PullImage(pullImageOptions, credentials[registrystr])
+	return apiRequest("POST", restartPath, nil, nil)
 }
 
-// RemoveContainer removes a single existing container.
-func RemoveContainer(c docker.APIContainers) error {
+// RemoveContainer stops a single existing container, giving it up to
+// stopTimeout to exit gracefully, then removes it along with any anonymous
+// volumes it owns.
+func RemoveContainer(c docker.APIContainers, stopTimeout time.Duration) error {
 
 	logrus.WithFields(logrus.Fields{
 		"container": strings.TrimPrefix(c.Names[0], "/"),
 	}).Info("Removing docker container")
 
-	// force removal of the container (remove even if it's running)
-	// along with any volumes it owns.
-	if err := client.RemoveContainer(docker.RemoveContainerOptions{
-		ID: c.ID, RemoveVolumes: true, Force: true,
+	if err := StopContainer(c.ID, stopTimeout); err != nil {
+		return err
+	}
+
+	if err := current().client.RemoveContainer(docker.RemoveContainerOptions{
+		ID: c.ID, RemoveVolumes: true,
 	}); err != nil {
 		return err
 	}
@@ -118,19 +285,91 @@ func RemoveContainer(c docker.APIContainers) error {
 	return nil
 }
 
+// ContainerOptions holds everything needed to create and start a new
+// container.
+type ContainerOptions struct {
+	// Name is the name given to the new container
+	Name string
+
+	// RepoTag is the image the container will be created from
+	RepoTag string
+
+	// Env is a slice of `KEY=value` environment variables
+	Env []string
+
+	// Entrypoint and Cmd override the image's entrypoint and command, and
+	// WorkingDir and User the directory and user they're run as, if set
+	Entrypoint []string
+	Cmd        []string
+	WorkingDir string
+	User       string
+
+	// Labels are applied to the container at creation time
+	Labels map[string]string
+
+	// DockerControlEnabled bind-mounts the docker socket and containers
+	// directory into the container
+	DockerControlEnabled bool
+
+	// PersistenceEnabled bind-mounts each of the image's volumes from a
+	// directory under PersistenceDir. The directory is named after
+	// PersistenceName, or Name if that isn't set.
+	PersistenceEnabled bool
+	PersistenceDir     string
+	PersistenceName    string
+
+	// Binds are additional volumes mounted into the container, in the form
+	// `source:target[:options]`. They take precedence over persistent
+	// volumes mounted at the same target.
+	Binds []string
+
+	// PortMapping maps host ports (keys) to container ports (values)
+	PortMapping map[int]int
+
+	// Healthcheck configures docker's native healthcheck for the container
+	Healthcheck *HealthConfig
+
+	// RestartPolicy controls whether docker restarts the container when it
+	// exits (docker's default, never, if empty)
+	RestartPolicy docker.RestartPolicy
+
+	// StopSignal is the signal sent to the container when it's stopped
+	// (docker's default, SIGTERM, if empty)
+	StopSignal string
+
+	// StopTimeout is how long the container is given to exit after being
+	// sent its stop signal before it's killed (docker's default if negative)
+	StopTimeout time.Duration
+
+	// Resources limits the memory, CPU and processes the container can use
+	Resources Resources
+}
+
+// createContainerRequest is the body sent to the docker API when creating a
+// new container. The vendored docker.Config doesn't support labels,
+// healthchecks or stop settings so they're added alongside it here, and
+// resource limits are added to the host config.
+type createContainerRequest struct {
+	*docker.Config
+	Labels      map[string]string `json:"Labels,omitempty"`
+	Healthcheck *HealthConfig     `json:"Healthcheck,omitempty"`
+	StopSignal  string            `json:"StopSignal,omitempty"`
+	StopTimeout *int              `json:"StopTimeout,omitempty"`
+	HostConfig  *createHostConfig `json:"HostConfig,omitempty"`
+}
+
 // StartContainer creates and starts a new container for the given container
-// definition. The name and port of the newly running container will be
-// returned along with the definition.
-func StartContainer(name string, repoTag string, env []string, dockerControlEnabled bool, persistenceEnabled bool, portMapping map[int]int, persistenceDir string) error {
+// definition, returning the ID of the new container.
+func StartContainer(opts ContainerOptions) (string, error) {
 
 	logrus.WithFields(logrus.Fields{
-		"container_name": name,
-		"repo_tag":       repoTag,
+		"container_name": opts.Name,
+		"repo_tag":       opts.RepoTag,
 	}).Info("Starting docker container")
 
 	// configure docker socket mount if required
 	var binds []string
-	if dockerControlEnabled {
+	if opts.DockerControlEnabled {
 		binds = []string{
 			"/var/run/docker.sock:/var/run/docker.sock",
 			"/var/lib/docker/containers:/var/lib/docker/containers",
@@ -140,42 +379,66 @@ func StartContainer(name string, repoTag string, env []string, dockerControlEnab
 	}
 
 	// configure volumes if persistence is enabled for this container
-	if persistenceEnabled {
-		image, err := InspectImage(repoTag)
+	if opts.PersistenceEnabled {
+		image, err := InspectImage(opts.RepoTag)
 		if err != nil {
-			return err
+			return "", err
+		}
+		persistenceName := opts.PersistenceName
+		if persistenceName == "" {
+			persistenceName = opts.Name
 		}
 		for volume, _ := range image.Config.Volumes {
-			mountPath := path.Join(persistenceDir, name, volume)
+			if bindsTarget(opts.Binds, volume) {
+				continue
+			}
+			mountPath := path.Join(opts.PersistenceDir, persistenceName, volume)
 			binds = append(binds, fmt.Sprintf("%s:%s", mountPath, volume))
 		}
 	}
 
+	binds = append(binds, opts.Binds...)
+
 	// convert portMapping map into the map[Port][]PortBinding that docker expects
-	portBindings := portMappingToPortBindings(portMapping)
+	portBindings := portMappingToPortBindings(opts.PortMapping)
 	// convert portMapping map into the map[Port]struct{} that docker expects
 	exposedPorts := make(map[docker.Port]struct{})
-	for _, internalPort := range portMapping {
+	for _, internalPort := range opts.PortMapping {
 		exposedPorts[docker.Port(fmt.Sprintf("%d/tcp", internalPort))] = struct{}{}
 		exposedPorts[docker.Port(fmt.Sprintf("%d/udp", internalPort))] = struct{}{}
 	}
 
-	hostConfig := docker.HostConfig{RestartPolicy: docker.RestartOnFailure(10), Binds: binds, PortBindings: portBindings}
-	createContainerOptions := docker.CreateContainerOptions{
-		Name:       name,
-		Config:     &docker.Config{Image: repoTag, Env: env, ExposedPorts: exposedPorts},
-		HostConfig: &hostConfig,
+	hostConfig := createHostConfig{
+		HostConfig: docker.HostConfig{RestartPolicy: opts.RestartPolicy, Binds: binds, PortBindings: portBindings},
+		Resources:  opts.Resources,
 	}
-
-	container, err := client.CreateContainer(createContainerOptions)
-	if err != nil {
-		return err
+	createContainerBody := createContainerRequest{
+		Config: &docker.Config{
+			Image:        opts.RepoTag,
+			Env:          opts.Env,
+			ExposedPorts: exposedPorts,
+			Entrypoint:   opts.Entrypoint,
+			Cmd:          opts.Cmd,
+			WorkingDir:   opts.WorkingDir,
+			User:         opts.User,
+		},
+		Labels:      opts.Labels,
+		Healthcheck: opts.Healthcheck,
+		StopSignal:  opts.StopSignal,
+		HostConfig:  &hostConfig,
+	}
+	if opts.StopTimeout >= 0 {
+		stopTimeout := timeoutSeconds(opts.StopTimeout)
+		createContainerBody.StopTimeout = &stopTimeout
 	}
 
-	err = client.StartContainer(container.ID, &hostConfig)
+	var container struct {
+		ID string `json:"Id"`
+	}
+	err := apiRequest("POST", "/containers/create?name="+url.QueryEscape(opts.Name), createContainerBody, &container)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return container.ID, apiRequest("POST", "/containers/"+container.ID+"/start", nil, nil)
 }
@@ -82,6 +82,9 @@ func mergeEnvs(origEnv, newEnv []string) []string {
 	// convert both slices into maps to make them easier to work with
 	origEnvMap := envSliceToMap(origEnv)
 	newEnvMap := envSliceToMap(newEnv)
+	if origEnvMap == nil {
+		origEnvMap = make(map[string]string)
+	}
 
 	// merge newEnv into origEnv, overwriting keys as necessary
 	for k, v := range newEnvMap {
@@ -92,26 +95,28 @@ func mergeEnvs(origEnv, newEnv []string) []string {
 	return envMapToSlice(origEnvMap)
 }
 
-// EnvsMatch checks if a running container's environment matches the one
-// defined in a container definition. The variables defined in the container
-// definition are added to those defined in the base image before comparing
-// with those read from the running container.
-func EnvsMatch(env0, env1, fromImage []string) bool {
+// EnvDifferences compares a running container's environment with the one
+// defined in a container definition, returning a sorted list of the variable
+// names that differ. The variables defined in the container definition are
+// added to those defined in the base image before comparing with those read
+// from the running container.
+func EnvDifferences(env0, env1, fromImage []string) []string {
 
-	env0 = mergeEnvs(fromImage, env0)
+	expected := envSliceToMap(mergeEnvs(fromImage, env0))
+	running := envSliceToMap(env1)
 
-	if len(env0) != len(env1) {
-		return false
+	var keys []string
+	for k, v := range expected {
+		if rv, ok := running[k]; !ok || rv != v {
+			keys = append(keys, k)
+		}
 	}
-
-	sort.Strings(env0)
-	sort.Strings(env1)
-
-	for i := range env0 {
-		if env0[i] != env1[i] {
-			return false
+	for k := range running {
+		if _, ok := expected[k]; !ok {
+			keys = append(keys, k)
 		}
 	}
 
-	return true
+	sort.Strings(keys)
+	return keys
 }
@@ -0,0 +1,70 @@
+package dockerclient
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// HealthConfig mirrors the healthcheck configuration accepted by the docker
+// API when creating a container (the equivalent of a Dockerfile's
+// HEALTHCHECK instruction). Durations are sent to docker in nanoseconds.
+type HealthConfig struct {
+	Test        []string      `json:"Test,omitempty"`
+	Interval    time.Duration `json:"Interval,omitempty"`
+	Timeout     time.Duration `json:"Timeout,omitempty"`
+	StartPeriod time.Duration `json:"StartPeriod,omitempty"`
+	Retries     int           `json:"Retries,omitempty"`
+}
+
+// ContainerHealth returns the health status docker reports for a container
+// (`starting`, `healthy` or `unhealthy`). An empty string is returned if the
+// container doesn't have a native healthcheck configured.
+func ContainerHealth(id string) (string, error) {
+
+	var container struct {
+		State struct {
+			Health *struct {
+				Status string
+			}
+		}
+	}
+	if err := apiRequest("GET", "/containers/"+id+"/json", nil, &container); err != nil {
+		return "", err
+	}
+
+	if container.State.Health == nil {
+		return "", nil
+	}
+	return container.State.Health.Status, nil
+}
+
+// ExecCommand runs a command inside a running container, waiting up to
+// timeout for it to complete, and returns its exit code.
+func ExecCommand(id string, cmd []string, timeout time.Duration) (int, error) {
+
+	exec, err := current().client.CreateExec(docker.CreateExecOptions{Container: id, Cmd: cmd})
+	if err != nil {
+		return -1, err
+	}
+
+	if err := current().client.StartExec(exec.ID, docker.StartExecOptions{Detach: true}); err != nil {
+		return -1, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		inspect, err := current().client.InspectExec(exec.ID)
+		if err != nil {
+			return -1, err
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+		if time.Now().After(deadline) {
+			return -1, fmt.Errorf("command timed out after %s", timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,91 @@
+package dockerclient
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+
+	"github.com/rehabstudio/oneill/config"
+)
+
+// validateIgnoreRules checks that each ignore rule sets exactly one matcher
+// and that any glob patterns are well formed.
+func validateIgnoreRules(rules []config.IgnoreRule) error {
+
+	for _, rule := range rules {
+		var matchers int
+		for _, pattern := range []string{rule.Name, rule.Image} {
+			if pattern == "" {
+				continue
+			}
+			matchers = matchers + 1
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid ignore pattern: %s", pattern)
+			}
+		}
+		if rule.Label != "" {
+			matchers = matchers + 1
+		}
+		if matchers != 1 {
+			return fmt.Errorf("Ignore rules must set exactly one of name, label or image")
+		}
+	}
+
+	return nil
+}
+
+// labelSelectorMatches reports whether the given labels satisfy a selector
+// in the form `key` or `key=value`.
+func labelSelectorMatches(selector string, labels map[string]string) bool {
+
+	parts := strings.SplitN(selector, "=", 2)
+	value, ok := labels[parts[0]]
+	if !ok {
+		return false
+	}
+
+	return len(parts) == 1 || value == parts[1]
+}
+
+// ignoreRuleMatches reports whether a single ignore rule matches the given
+// container.
+func ignoreRuleMatches(rule config.IgnoreRule, c docker.APIContainers) bool {
+
+	switch {
+	case rule.Name != "":
+		for _, name := range c.Names {
+			if ok, _ := path.Match(rule.Name, strings.TrimPrefix(name, "/")); ok {
+				return true
+			}
+		}
+	case rule.Image != "":
+		ok, _ := path.Match(rule.Image, c.Image)
+		return ok
+	case rule.Label != "":
+		labels, err := ContainerLabels(c.ID)
+		return err == nil && labelSelectorMatches(rule.Label, labels)
+	}
+
+	return false
+}
+
+// Ignored reports whether the given container matches one of the configured
+// ignore rules. oneill should never inspect, replace or remove an ignored
+// container.
+func Ignored(c docker.APIContainers) bool {
+
+	for _, rule := range current().ignoreRules {
+		if ignoreRuleMatches(rule, c) {
+			logrus.WithFields(logrus.Fields{
+				"container": strings.TrimPrefix(c.Names[0], "/"),
+				"image":     c.Image,
+			}).Debug("Skipping ignored docker container")
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,6 +2,8 @@ package dockerclient
 
 import (
 	"path"
+	"sort"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 )
@@ -32,14 +34,58 @@ func DockerSocketMounted(binds []string) bool {
 	return false
 }
 
-// AllVolumesmounted checks that all the volumes defined in an image are appropriately mounted in our central persistence directory
-func AllVolumesMounted(containerName, persistenceDir, imageName string, volumes map[string]string) bool {
+// bindTarget returns the path within the container that a bind in the form
+// `source:target[:options]` is mounted at.
+func bindTarget(bind string) string {
+	parts := strings.SplitN(bind, ":", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// bindsTarget checks whether any of the given binds is mounted at target.
+func bindsTarget(binds []string, target string) bool {
+	for _, bind := range binds {
+		if bindTarget(bind) == target {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingBinds returns each of the expected binds that isn't present in a
+// container's binds.
+func MissingBinds(expected, binds []string) []string {
+
+	var missing []string
+	for _, e := range expected {
+		found := false
+		for _, bind := range binds {
+			if bind == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, e)
+		}
+	}
+
+	return missing
+}
+
+// MissingVolumeBinds returns a sorted list of the volumes defined in an image
+// that aren't bind-mounted at the expected location in our central
+// persistence directory.
+func MissingVolumeBinds(containerName, persistenceDir, imageName string, volumes map[string]string) ([]string, error) {
 
 	image, err := InspectImage(imageName)
 	if err != nil {
-		return false
+		return nil, err
 	}
 
+	var missing []string
 	for volume, _ := range image.Config.Volumes {
 		internalMountPath, ok := volumes[volume]
 		if !ok {
@@ -47,7 +93,8 @@ func AllVolumesMounted(containerName, persistenceDir, imageName string, volumes
 				"container_name": containerName,
 				"volume":         volume,
 			}).Debug("Volume not mounted")
-			return false
+			missing = append(missing, volume)
+			continue
 		}
 		expectedMountPath := path.Join(persistenceDir, containerName, volume)
 		if internalMountPath != expectedMountPath {
@@ -57,9 +104,10 @@ func AllVolumesMounted(containerName, persistenceDir, imageName string, volumes
 				"internal_mount_path": internalMountPath,
 				"volume":              volume,
 			}).Debug("Volume not mounted at correct path")
-			return false
+			missing = append(missing, volume)
 		}
 	}
 
-	return true
+	sort.Strings(missing)
+	return missing, nil
 }
@@ -0,0 +1,198 @@
+package dockerclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// pullDedupeWindow is how long a successful pull is reused for. Definitions
+// sharing an image (replicas, a migration job and the service that depends on
+// it, etc.) only cause it to be pulled once per run.
+const pullDedupeWindow = time.Minute
+
+// PullOptions controls how hard PullImage tries to pull an image.
+type PullOptions struct {
+	// Attempts is the maximum number of times a pull is attempted
+	Attempts int
+
+	// Backoff is the delay before the first retry, doubling after each
+	// subsequent failure
+	Backoff time.Duration
+
+	// Timeout is the maximum time a single attempt may take (no limit if
+	// zero)
+	Timeout time.Duration
+}
+
+// pull tracks a single pull of an image, shared by everyone who requests
+// the same image while it's in progress (and for a short while after).
+type pull struct {
+	done     chan struct{}
+	err      error
+	finished time.Time
+}
+
+var (
+	pullsLock sync.Mutex
+	pulls     = make(map[string]*pull)
+)
+
+// permanentPullError wraps pull errors that will never succeed on retry,
+// e.g. bad credentials or an image that doesn't exist.
+type permanentPullError struct {
+	err error
+}
+
+func (e *permanentPullError) Error() string {
+	return e.err.Error()
+}
+
+// classifyPullError marks auth and not-found errors as permanent, anything
+// else (connection resets, timeouts, registry 5xx responses, etc.) is
+// assumed to be transient.
+func classifyPullError(err error) error {
+
+	if dockerErr, ok := err.(*docker.Error); ok {
+		switch dockerErr.Status {
+		case 401, 403, 404:
+			return &permanentPullError{err}
+		}
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, permanent := range []string{"not found", "unauthorized", "authentication required", "denied", "manifest unknown", "does not exist"} {
+		if strings.Contains(msg, permanent) {
+			return &permanentPullError{err}
+		}
+	}
+
+	return err
+}
+
+// pullOnce makes a single attempt to pull an image, giving up after timeout.
+// Errors reported by docker part way through the pull are returned too.
+func pullOnce(repository, tag string, auth docker.AuthConfiguration, timeout time.Duration) error {
+
+	query := url.Values{"fromImage": {repository}, "tag": {tag}}
+	httpClient, req, err := newAPIRequest("POST", "/images/create?"+query.Encode(), nil, timeout)
+	if err != nil {
+		return err
+	}
+
+	authJSON, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Registry-Auth", base64.URLEncoding.EncodeToString(authJSON))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		var msg struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&msg)
+		return &docker.Error{Status: resp.StatusCode, Message: msg.Message}
+	}
+
+	// progress is streamed as a sequence of JSON messages, any of which may
+	// report an error
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("%s", msg.Error)
+		}
+	}
+}
+
+// pullWithRetries pulls an image, retrying transient failures with
+// exponential backoff.
+func pullWithRetries(repoTag string, opts PullOptions) error {
+
+	// the docker API accepts a digest in place of a tag, with neither it
+	// would pull every tag in the repository
+	repository, tag, digest := ParseImageReference(repoTag)
+	if digest != "" {
+		tag = digest
+	} else if tag == "" {
+		tag = "latest"
+	}
+
+	// the registry is the first part of the repository
+	registry := strings.Split(repository, "/")[0]
+
+	backoff := opts.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = classifyPullError(pullOnce(repository, tag, current().credentials[registry], opts.Timeout))
+		if err == nil {
+			return nil
+		}
+		if _, permanent := err.(*permanentPullError); permanent || attempt >= opts.Attempts {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"repo_tag": repoTag,
+			"attempt":  attempt,
+			"retry_in": backoff.String(),
+			"err":      err,
+		}).Warning("Unable to pull image, retrying")
+		time.Sleep(backoff)
+		backoff = backoff * 2
+	}
+}
+
+// PullImage pulls the latest image for the given image reference from a
+// remote registry. References can be pinned to an immutable digest
+// (`image@sha256:...`), in which case exactly that image is pulled.
+// Credentials (if provided) are used in all requests to private registries.
+// Concurrent pulls of the same image are deduplicated, as are pulls
+// requested shortly after a successful one.
+func PullImage(repoTag string, opts PullOptions) error {
+
+	pullsLock.Lock()
+	p, ok := pulls[repoTag]
+	if ok && (p.finished.IsZero() || (p.err == nil && time.Since(p.finished) < pullDedupeWindow)) {
+		pullsLock.Unlock()
+		<-p.done
+		return p.err
+	}
+	p = &pull{done: make(chan struct{})}
+	pulls[repoTag] = p
+	pullsLock.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"repo_tag": repoTag,
+	}).Debug("Pulling latest image from registry")
+
+	err := pullWithRetries(repoTag, opts)
+
+	pullsLock.Lock()
+	p.err, p.finished = err, time.Now()
+	pullsLock.Unlock()
+	close(p.done)
+
+	return err
+}
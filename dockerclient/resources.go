@@ -0,0 +1,120 @@
+package dockerclient
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// CPUPeriod is the CFS scheduler period (in microseconds) used with a CPU
+// quota, docker's default.
+const CPUPeriod = 100000
+
+var rxBytes = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kmgt]?)(?:i?b)?$`)
+
+// byteUnits maps the unit suffixes accepted by ParseBytes to multipliers.
+// Like docker, units are always binary (1k is 1024 bytes).
+var byteUnits = map[string]int64{
+	"":  1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// ParseBytes parses a size in bytes with an optional unit, in the same form
+// as `docker run --memory`, e.g. `512m`, `1.5g`, `2GiB` or `1048576`.
+func ParseBytes(s string) (int64, error) {
+
+	m := rxBytes.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("not a valid size: %s", s)
+	}
+
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("not a valid size: %s", s)
+	}
+
+	return int64(n * float64(byteUnits[m[2]])), nil
+}
+
+// Resources mirrors the resource limits accepted by the docker API in a
+// container's HostConfig, which the vendored docker.HostConfig doesn't
+// support. Zero values mean no limit.
+type Resources struct {
+	Memory     int64  `json:"Memory,omitempty"`
+	MemorySwap int64  `json:"MemorySwap,omitempty"`
+	CPUShares  int64  `json:"CpuShares,omitempty"`
+	NanoCPUs   int64  `json:"NanoCpus,omitempty"`
+	CPUQuota   int64  `json:"CpuQuota,omitempty"`
+	CPUPeriod  int64  `json:"CpuPeriod,omitempty"`
+	CpusetCpus string `json:"CpusetCpus,omitempty"`
+	PidsLimit  int64  `json:"PidsLimit,omitempty"`
+}
+
+// createHostConfig is the HostConfig sent to the docker API when creating a
+// container, docker.HostConfig with resource limits added.
+type createHostConfig struct {
+	docker.HostConfig
+	Resources
+}
+
+// ContainerResources returns the resource limits applied to an existing
+// container.
+func ContainerResources(id string) (Resources, error) {
+
+	var container struct {
+		HostConfig Resources
+	}
+	if err := apiRequest("GET", "/containers/"+id+"/json", nil, &container); err != nil {
+		return Resources{}, err
+	}
+
+	return container.HostConfig, nil
+}
+
+// ResourceDifferences compares the resource limits applied to a running
+// container with those expected, returning a list of the limits that differ.
+// Docker fills in some values itself (swap defaults to twice the memory
+// limit, and an unlimited pids limit may be reported as 0 or -1), which is
+// taken into account.
+func ResourceDifferences(expected, running Resources) []string {
+
+	if expected.Memory > 0 && expected.MemorySwap == 0 {
+		expected.MemorySwap = expected.Memory * 2
+	}
+	if expected.PidsLimit < 0 {
+		expected.PidsLimit = 0
+	}
+	if running.PidsLimit < 0 {
+		running.PidsLimit = 0
+	}
+	if expected.CPUQuota == 0 {
+		expected.CPUPeriod, running.CPUPeriod = 0, 0
+	}
+
+	var differences []string
+	for _, field := range []struct {
+		name              string
+		expected, running interface{}
+	}{
+		{"memory", expected.Memory, running.Memory},
+		{"memory_swap", expected.MemorySwap, running.MemorySwap},
+		{"cpu_shares", expected.CPUShares, running.CPUShares},
+		{"cpus", expected.NanoCPUs, running.NanoCPUs},
+		{"cpu_quota", expected.CPUQuota, running.CPUQuota},
+		{"cpu_period", expected.CPUPeriod, running.CPUPeriod},
+		{"cpuset", expected.CpusetCpus, running.CpusetCpus},
+		{"pids_limit", expected.PidsLimit, running.PidsLimit},
+	} {
+		if field.expected != field.running {
+			differences = append(differences, field.name)
+		}
+	}
+
+	return differences
+}
@@ -0,0 +1,58 @@
+package dockerclient
+
+import "testing"
+
+func TestParseBytes(t *testing.T) {
+
+	tests := []struct {
+		size     string
+		expected int64
+	}{
+		{"0", 0},
+		{"1048576", 1048576},
+		{"512b", 512},
+		{"1k", 1024},
+		{"1kb", 1024},
+		{"1KiB", 1024},
+		{"512m", 512 << 20},
+		{"512M", 512 << 20},
+		{"512MB", 512 << 20},
+		{"1.5g", 3 << 29},
+		{"2GiB", 2 << 30},
+		{"1t", 1 << 40},
+		{" 64m ", 64 << 20},
+		{"64 m", 64 << 20},
+	}
+
+	for _, test := range tests {
+		n, err := ParseBytes(test.size)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.size, err)
+			continue
+		}
+		if n != test.expected {
+			t.Errorf("Expected %q to be %d bytes, got %d", test.size, test.expected, n)
+		}
+	}
+}
+
+func TestParseBytesErrors(t *testing.T) {
+
+	sizes := []string{
+		"",
+		"m",
+		"-1",
+		"-512m",
+		"1.5.2g",
+		"512x",
+		"512mm",
+		"1pb",
+		"one gigabyte",
+	}
+
+	for _, size := range sizes {
+		if _, err := ParseBytes(size); err == nil {
+			t.Errorf("Expected error parsing %q", size)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// eventDebounce is how long the watcher waits after the last relevant docker
+// event before triggering a reconcile, so that a burst of events (e.g. `die`
+// followed by `destroy`) only causes a single reconcile.
+const eventDebounce = 2 * time.Second
+
+// eventWatcher subscribes to the docker events API and collects the names of
+// containers affected by events that might require oneill to take action.
+type eventWatcher struct {
+	events   chan *docker.APIEvents
+	listener chan *docker.APIEvents
+	done     chan struct{}
+	names    map[string]string
+	pending  map[string]bool
+	debounce <-chan time.Time
+}
+
+// newEventWatcher initialises a new eventWatcher, it will not receive any
+// events until subscribe is called.
+func newEventWatcher() *eventWatcher {
+	return &eventWatcher{
+		names:   make(map[string]string),
+		pending: make(map[string]bool),
+	}
+}
+
+// subscribe starts listening to the docker events API if not already doing
+// so. A failure to subscribe is logged but isn't fatal, the daemon will still
+// reconcile on its regular interval.
+func (w *eventWatcher) subscribe() {
+
+	if w.events != nil {
+		return
+	}
+
+	listener := make(chan *docker.APIEvents, 100)
+	if err := dockerclient.AddEventListener(listener); err != nil {
+		logrus.WithFields(logrus.Fields{"err": err}).Warning("Unable to subscribe to docker events")
+		return
+	}
+
+	w.listener = listener
+	w.events = make(chan *docker.APIEvents)
+	w.done = make(chan struct{})
+	go forwardEvents(w.listener, w.events, w.done)
+}
+
+// unsubscribe stops listening to the docker events API. This must be called
+// before the docker client is re-initialised. Events keep being drained
+// until the listener has been removed, the docker client blocks on sending
+// to its listeners while holding the lock needed to remove them.
+func (w *eventWatcher) unsubscribe() {
+
+	if w.events == nil {
+		return
+	}
+
+	if err := dockerclient.RemoveEventListener(w.listener); err != nil {
+		logrus.WithFields(logrus.Fields{"err": err}).Warning("Unable to unsubscribe from docker events")
+	}
+	close(w.done)
+
+	w.events, w.listener, w.done = nil, nil, nil
+}
+
+// forwardEvents reads every event the docker client sends to in as soon as
+// it's sent, queueing them until they can be delivered to out. The docker
+// client is never blocked waiting for oneill's main loop, which can be busy
+// with a reconcile for a long time. A nil event is delivered once in is
+// closed, forwarding stops once done is closed.
+func forwardEvents(in <-chan *docker.APIEvents, out chan<- *docker.APIEvents, done <-chan struct{}) {
+
+	var queue []*docker.APIEvents
+	closed := false
+	for {
+		// only try to deliver when there's something queued
+		var send chan<- *docker.APIEvents
+		var next *docker.APIEvents
+		if len(queue) > 0 {
+			send, next = out, queue[0]
+		}
+
+		// stop reading once the docker client has closed the channel
+		receive := in
+		if closed {
+			receive = nil
+		}
+
+		select {
+		case event, ok := <-receive:
+			if !ok {
+				closed = true
+				event = nil
+			}
+			queue = append(queue, event)
+		case send <- next:
+			queue = queue[1:]
+		case <-done:
+			return
+		}
+	}
+}
+
+// refresh rebuilds the cache used to map container IDs to names. Docker
+// events only include a container's ID, and once a container has been
+// destroyed its name can no longer be looked up.
+func (w *eventWatcher) refresh() {
+
+	containers, err := dockerclient.ListContainers()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"err": err}).Warning("Unable to list containers")
+		return
+	}
+
+	w.names = make(map[string]string)
+	for _, c := range containers {
+		w.names[c.ID] = strings.TrimPrefix(c.Names[0], "/")
+	}
+}
+
+// record handles a single docker event, marking the affected container as
+// pending a reconcile if necessary.
+func (w *eventWatcher) record(event *docker.APIEvents) {
+
+	// a nil event means the events channel has been closed by the docker
+	// client, we'll try to subscribe again after the next full cycle
+	if event == nil {
+		logrus.Warning("Docker event stream closed")
+		close(w.done)
+		w.events, w.listener, w.done = nil, nil, nil
+		return
+	}
+
+	switch event.Status {
+	case "start", "die", "destroy":
+	default:
+		return
+	}
+
+	// try to resolve the container name, falling back to our cache for
+	// containers that no longer exist
+	if c, err := dockerclient.InspectContainer(event.ID); err == nil {
+		w.names[event.ID] = strings.TrimPrefix(c.Name, "/")
+	}
+	name, ok := w.names[event.ID]
+	if !ok {
+		logrus.WithFields(logrus.Fields{
+			"container_id": event.ID,
+			"status":       event.Status,
+		}).Debug("Ignoring docker event for unknown container")
+		return
+	}
+	if event.Status == "destroy" {
+		delete(w.names, event.ID)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"container_name": name,
+		"status":         event.Status,
+	}).Debug("Received docker event")
+
+	w.pending[name] = true
+	w.debounce = time.After(eventDebounce)
+}
+
+// flush returns the names of all containers pending a reconcile, resetting
+// the watcher's state.
+func (w *eventWatcher) flush() []string {
+
+	var names []string
+	for name := range w.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.pending = make(map[string]bool)
+	w.debounce = nil
+
+	return names
+}
@@ -0,0 +1,323 @@
+// Package history records every set of container definitions oneill applies,
+// along with the outcome for each container, so that past deployments can be
+// listed and rolled back to.
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/containerdefs"
+	"github.com/rehabstudio/oneill/dockerclient"
+)
+
+// ContainerRecord describes the container for a single definition at the
+// end of a run: what was done to it, the spec and image it was running and
+// whether anything went wrong.
+type ContainerRecord struct {
+	ContainerName string               `json:"container_name"`
+	Action        containerdefs.Action `json:"action"`
+	SpecHash      string               `json:"spec_hash"`
+	RepoTag       string               `json:"repo_tag"`
+	RepoDigest    string               `json:"repo_digest,omitempty"`
+	ImageID       string               `json:"image_id,omitempty"`
+	Error         string               `json:"error,omitempty"`
+}
+
+// Revision is a single applied set of container definitions.
+type Revision struct {
+	ID          int                                  `json:"id"`
+	Timestamp   time.Time                            `json:"timestamp"`
+	Source      string                               `json:"source"`
+	ExitCode    int                                  `json:"exit_code"`
+	Definitions []*containerdefs.ContainerDefinition `json:"definitions"`
+	Containers  []*ContainerRecord                   `json:"containers"`
+}
+
+// Outcome summarises the revision's exit code in words.
+func (r *Revision) Outcome() string {
+	switch r.ExitCode {
+	case containerdefs.ExitSuccess:
+		return "success"
+	case containerdefs.ExitPartialFailure:
+		return "partial failure"
+	default:
+		return "failure"
+	}
+}
+
+// definitionsHash identifies a set of definitions, allowing unchanged
+// definitions to be detected.
+func definitionsHash(cdefs []*containerdefs.ContainerDefinition) string {
+	data, _ := json.Marshal(cdefs)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// PinnedDefinitions returns copies of the revision's definitions with each
+// repo_tag pinned to the image digest that was deployed, so that re-applying
+// them runs exactly the same images even if the tags have since moved.
+func (r *Revision) PinnedDefinitions() []*containerdefs.ContainerDefinition {
+
+	digests := make(map[string]string)
+	for _, c := range r.Containers {
+		digests[c.ContainerName] = c.RepoDigest
+	}
+
+	var cdefs []*containerdefs.ContainerDefinition
+	for _, cd := range r.Definitions {
+		pinned := *cd
+		repository, tag, digest := dockerclient.ParseImageReference(cd.RepoTag)
+		if digest == "" && digests[cd.ContainerName] != "" {
+			if tag != "" {
+				repository = repository + ":" + tag
+			}
+			pinned.RepoTag = repository + "@" + digests[cd.ContainerName]
+		}
+		cdefs = append(cdefs, &pinned)
+	}
+
+	return cdefs
+}
+
+func revisionsDir(conf *config.Configuration) string {
+	return path.Join(conf.StateDirectory, "revisions")
+}
+
+func revisionPath(conf *config.Configuration, id int) string {
+	return path.Join(revisionsDir(conf), fmt.Sprintf("%06d.json", id))
+}
+
+// revisionIDs returns the ID of every recorded revision, oldest first.
+func revisionIDs(conf *config.Configuration) ([]int, error) {
+
+	files, err := ioutil.ReadDir(revisionsDir(conf))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// List returns every recorded revision, oldest first.
+func List(conf *config.Configuration) ([]*Revision, error) {
+
+	ids, err := revisionIDs(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []*Revision
+	for _, id := range ids {
+		r, err := Load(conf, id)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+
+	sort.Sort(byID(revisions))
+	return revisions, nil
+}
+
+// byID sorts revisions by ID
+type byID []*Revision
+
+func (b byID) Len() int           { return len(b) }
+func (b byID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byID) Less(i, j int) bool { return b[i].ID < b[j].ID }
+
+// Load reads a single revision.
+func Load(conf *config.Configuration, id int) (*Revision, error) {
+
+	data, err := ioutil.ReadFile(revisionPath(conf, id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Revision not found: %d", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var r Revision
+	return &r, json.Unmarshal(data, &r)
+}
+
+// containerRecord inspects the current container for a definition.
+func containerRecord(conf *config.Configuration, cd *containerdefs.ContainerDefinition) *ContainerRecord {
+
+	record := &ContainerRecord{
+		ContainerName: cd.ContainerName,
+		Action:        containerdefs.ActionNone,
+		SpecHash:      cd.SpecHash(conf),
+		RepoTag:       cd.RepoTag,
+	}
+
+	c, err := dockerclient.GetContainerByName(cd.ContainerName)
+	if err != nil {
+		return record
+	}
+	if container, err := dockerclient.InspectContainer(c.ID); err == nil {
+		record.ImageID = container.Image
+	}
+	if labels, err := dockerclient.ContainerLabels(c.ID); err == nil {
+		record.RepoDigest = labels[containerdefs.LabelRepoDigest]
+	}
+
+	return record
+}
+
+// Record stores a new revision for a completed run, unless nothing changed
+// since the previous revision: the definitions are the same and no
+// containers were started, recreated, run or removed.
+func Record(conf *config.Configuration, source string, cdefs []*containerdefs.ContainerDefinition, report *containerdefs.Report) (*Revision, error) {
+
+	ids, err := revisionIDs(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed bool
+	r := &Revision{
+		ID:          1,
+		Timestamp:   report.Finished,
+		Source:      source,
+		ExitCode:    report.ExitCode(),
+		Definitions: cdefs,
+	}
+
+	records := make(map[string]*ContainerRecord)
+	for _, cd := range cdefs {
+		record := containerRecord(conf, cd)
+		records[cd.ContainerName] = record
+		r.Containers = append(r.Containers, record)
+	}
+	for _, result := range report.Results {
+		if result.Action != containerdefs.ActionNone {
+			changed = true
+		}
+		record, ok := records[result.ContainerName]
+		if !ok {
+			record = &ContainerRecord{ContainerName: result.ContainerName}
+			records[result.ContainerName] = record
+			r.Containers = append(r.Containers, record)
+		}
+		record.Action = result.Action
+		record.Error = result.Error
+	}
+
+	if len(ids) > 0 {
+		latest, err := Load(conf, ids[len(ids)-1])
+		if err != nil {
+			return nil, err
+		}
+		r.ID = latest.ID + 1
+		if !changed && r.ExitCode == latest.ExitCode && definitionsHash(cdefs) == definitionsHash(latest.Definitions) {
+			return latest, nil
+		}
+	}
+
+	// revisions include each definition's environment, which often
+	// contains secrets, so they're only readable by oneill's own user
+	if err := os.MkdirAll(revisionsDir(conf), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(revisionsDir(conf), 0700); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(revisionPath(conf, r.ID), data, 0600); err != nil {
+		return nil, err
+	}
+
+	return r, prune(conf, append(ids, r.ID))
+}
+
+// prune removes the oldest revisions so that no more than the configured
+// history_limit are kept. ids must be sorted, oldest first.
+func prune(conf *config.Configuration, ids []int) error {
+
+	limit := conf.HistoryLimit
+	if limit < 1 {
+		limit = 1
+	}
+
+	for len(ids) > limit {
+		if err := os.Remove(revisionPath(conf, ids[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		ids = ids[1:]
+	}
+
+	return nil
+}
+
+// History is the list of revisions printed by the `history` command.
+type History struct {
+	Revisions []*Revision `json:"revisions"`
+}
+
+// WriteText writes a human readable summary of each revision to w.
+func (h *History) WriteText(w io.Writer) error {
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "REVISION\tAPPLIED\tSOURCE\tCONTAINERS\tCHANGES\tOUTCOME\n")
+	for _, r := range h.Revisions {
+		var changes []string
+		for _, c := range r.Containers {
+			if c.Action != containerdefs.ActionNone {
+				changes = append(changes, fmt.Sprintf("%s %s", c.Action, c.ContainerName))
+			}
+		}
+		if len(changes) == 0 {
+			changes = []string{"-"}
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\n", r.ID, r.Timestamp.Format(time.RFC3339), r.Source, len(r.Definitions), strings.Join(changes, ", "), r.Outcome())
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON writes every revision to w as a JSON document.
+func (h *History) WriteJSON(w io.Writer) error {
+
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
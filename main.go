@@ -5,14 +5,18 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 
 	"github.com/rehabstudio/oneill/config"
 	"github.com/rehabstudio/oneill/containerdefs"
 	"github.com/rehabstudio/oneill/dockerclient"
+	"github.com/rehabstudio/oneill/history"
 	"github.com/rehabstudio/oneill/loaders"
 )
 
@@ -32,65 +36,352 @@ func exitOnError(err error, prefix string) {
 	}
 }
 
+// cliArgs holds the flags and command passed to oneill on the command line
+type cliArgs struct {
+	command        string
+	commandArgs    []string
+	configFilePath string
+	format         string
+	force          bool
+	showVersion    bool
+}
+
 // parseCliArgs parses any arguments passed to oneill on the command line
-func parseCliArgs() (string, bool) {
+func parseCliArgs() cliArgs {
 
 	// parse config file location from command line flag
 	configFilePath := flag.String("config", "/etc/oneill/config.yaml", "location of the oneill config file")
+	format := flag.String("format", "text", "output format used by the plan, status and history commands (text or json)")
+	force := flag.Bool("force", false, "remove redundant containers even if doing so exceeds max_removals or max_removal_percent")
 	showVersion := flag.Bool("v", false, "show version details and exit")
 	flag.Parse()
 
-	return *configFilePath, *showVersion
-}
-
-func main() {
+	// the command is optional, oneill applies container definitions by default
+	command := "apply"
+	var commandArgs []string
+	if flag.NArg() > 0 {
+		command = flag.Arg(0)
+		commandArgs = flag.Args()[1:]
+	}
 
-	configFilePath, showVersion := parseCliArgs()
-	if showVersion {
-		fmt.Printf("oneill v%s\n\n", version)
-		fmt.Printf("buildDate:     %s\n", buildDate)
-		fmt.Printf("gitBranch:     %s\n", gitBranch)
-		fmt.Printf("gitRevision:   %s\n", gitRevision)
-		fmt.Printf("gitRepoStatus: %s\n", gitRepoStatus)
-		os.Exit(0)
+	return cliArgs{
+		command:        command,
+		commandArgs:    commandArgs,
+		configFilePath: *configFilePath,
+		format:         *format,
+		force:          *force,
+		showVersion:    *showVersion,
 	}
+}
 
-	// binding to a local socket ensures that only one instance of oneill is
-	// running at any one time. The socket will be automatically freed up once
-	// the process exits (cleanly or otherwise).
-	l, err := net.Listen("tcp", "127.0.0.1:57922")
-	exitOnError(err, "oneill is already running")
+// loadConfiguration loads oneill's configuration from disk and configures
+// the global logger and docker client instances accordingly.
+func loadConfiguration(configFilePath string) (*config.Configuration, error) {
 
 	config, err := config.LoadConfig(configFilePath)
-	exitOnError(err, "Unable to load configuration")
+	if err != nil {
+		return config, err
+	}
 
 	logLevel, err := logrus.ParseLevel(config.LogLevel)
-	exitOnError(err, "Unable to initialise logger")
+	if err != nil {
+		return config, err
+	}
+
+	for name, value := range map[string]string{"pull_backoff": config.PullBackoff, "pull_timeout": config.PullTimeout} {
+		if _, err := time.ParseDuration(value); err != nil {
+			return config, fmt.Errorf("not a valid value for %s: %s", name, value)
+		}
+	}
+
+	if err := containerdefs.ValidateMaintenanceWindows(config); err != nil {
+		return config, err
+	}
+
+	if _, err := containerdefs.ParseRestartPolicy(config.RestartPolicy); err != nil {
+		return config, err
+	}
 
 	// configure global logger instance
 	logrus.SetLevel(logLevel)
 	if config.LogFormat == "json" {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logrus.SetFormatter(&logrus.TextFormatter{})
 	}
 
-	err = dockerclient.InitDockerClient(config.DockerApiEndpoint, config.RegistryCredentials)
-	exitOnError(err, "Unable to initialise docker client")
+	err = dockerclient.InitDockerClient(config.DockerApiEndpoint, config.RegistryCredentials, config.Ignore)
+	if err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// initialise loads oneill's configuration, exiting if anything goes wrong.
+func initialise(configFilePath string) *config.Configuration {
+	config, err := loadConfiguration(configFilePath)
+	exitOnError(err, "Unable to initialise oneill")
+	return config
+}
+
+// loadDefinitions loads and validates container definitions from the
+// configured source, also returning the names of any definitions that failed
+// validation.
+func loadDefinitions(config *config.Configuration) ([]*containerdefs.ContainerDefinition, []string, error) {
 
-	// load container definitions
 	definitionLoader, err := loaders.GetLoader(config.DefinitionsURI)
-	exitOnError(err, "Unable to load container definitions")
-	definitions, err := containerdefs.LoadContainerDefinitions(definitionLoader)
-	exitOnError(err, "Unable to load container definitions")
+	if err != nil {
+		return []*containerdefs.ContainerDefinition{}, nil, err
+	}
+
+	return containerdefs.LoadContainerDefinitions(config, definitionLoader)
+}
+
+// reconcile loads container definitions and brings the containers running on
+// the host in line with them. The loaded definitions (and the names of any
+// that failed validation) are returned so that callers can perform targeted
+// reconciles later on, along with a report of every action taken. An error is
+// only returned if the run couldn't be attempted at all, failures for
+// individual containers are recorded in the report. The removal limits are
+// ignored if force is set.
+func reconcile(config *config.Configuration, force bool) ([]*containerdefs.ContainerDefinition, []string, *containerdefs.Report, error) {
+
+	report := containerdefs.NewReport()
+	defer report.Finish()
+
+	// load container definitions
+	definitions, invalid, err := loadDefinitions(config)
+	if err != nil {
+		return nil, nil, report, fmt.Errorf("Unable to load container definitions: %s", err)
+	}
+
+	// callers rely on nil meaning the definitions couldn't be loaded
+	if definitions == nil {
+		definitions = []*containerdefs.ContainerDefinition{}
+	}
+
+	err = applyDefinitions(config, report, definitions, invalid, config.DefinitionsURI, force)
+	return definitions, invalid, report, err
+}
+
+// applyDefinitions brings the containers running on the host in line with
+// the given definitions, recording every action taken in report. Once
+// complete, the definitions and the outcome for each container are recorded
+// as a new revision in oneill's history.
+func applyDefinitions(config *config.Configuration, report *containerdefs.Report, definitions []*containerdefs.ContainerDefinition, invalid []string, source string, force bool) error {
 
 	// stop redundant containers
-	err = containerdefs.RemoveRedundantContainers(definitions)
-	exitOnError(err, "Unable to remove redundant containers")
+	results, err := containerdefs.RemoveRedundantContainers(config, definitions, invalid, force)
+	if err != nil {
+		return fmt.Errorf("Unable to remove redundant containers: %s", err)
+	}
+	report.Add(results...)
 
 	// process all container definitions
-	err = containerdefs.ProcessContainerDefinitions(config, definitions)
-	exitOnError(err, "Unable to process service container definitions")
+	report.Add(containerdefs.ProcessContainerDefinitions(config, definitions)...)
+
+	// clean up old images, but only once everything is known to be running
+	// correctly on the new ones
+	if config.ImageGC && report.ExitCode() == containerdefs.ExitSuccess {
+		if err := containerdefs.RemoveSupersededImages(config, definitions); err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Error("Unable to remove superseded images")
+		}
+	}
+
+	report.Finish()
+	if _, err := history.Record(config, source, definitions, report); err != nil {
+		logrus.WithFields(logrus.Fields{"err": err}).Error("Unable to record revision history")
+	}
+
+	return nil
+}
+
+// finishReport logs a summary of a completed run and writes the report to
+// disk if configured to do so.
+func finishReport(config *config.Configuration, report *containerdefs.Report) {
+
+	report.LogSummary()
+	if config.ReportFile == "" {
+		return
+	}
+
+	if err := report.WriteFile(config.ReportFile); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"path": config.ReportFile,
+			"err":  err,
+		}).Error("Unable to write report file")
+	}
+}
+
+// lock binds to a local socket, ensuring that only one instance of oneill is
+// running at any one time. The socket will be automatically freed up once the
+// process exits (cleanly or otherwise).
+func lock() net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:57922")
+	exitOnError(err, "oneill is already running")
+	return l
+}
+
+// apply brings the containers running on the host in line with the loaded
+// container definitions. oneill exits with a status code of 3 if some
+// containers couldn't be started or removed, or 4 if none could.
+func apply(configFilePath string, force bool) {
+
+	l := lock()
+
+	config := initialise(configFilePath)
+	_, _, report, err := reconcile(config, force)
+	exitOnError(err, "Unable to apply container definitions")
+	finishReport(config, report)
 
 	// explicitly close the listening socket
 	l.Close()
 
+	// exit with a status code reflecting whether any containers failed
+	os.Exit(report.ExitCode())
+}
+
+// report is implemented by anything oneill can print as either text or JSON
+type report interface {
+	WriteText(w io.Writer) error
+	WriteJSON(w io.Writer) error
+}
+
+// validateFormat checks that the output format passed on the command line is
+// one we know how to print.
+func validateFormat(format string) {
+	if format != "text" && format != "json" {
+		exitOnError(fmt.Errorf("unknown format: %s", format), "Unable to print output")
+	}
+}
+
+// printReport writes a report to stdout in the requested format.
+func printReport(r report, format string) {
+
+	var err error
+	if format == "json" {
+		err = r.WriteJSON(os.Stdout)
+	} else {
+		err = r.WriteText(os.Stdout)
+	}
+	exitOnError(err, "Unable to print output")
+}
+
+// plan prints every action oneill would take without changing anything on
+// the host. oneill exits with a status code of 2 if any changes are pending
+// so that it can be used to gate changes in CI.
+func plan(configFilePath, format string) {
+
+	validateFormat(format)
+	config := initialise(configFilePath)
+
+	// load container definitions
+	definitions, invalid, err := loadDefinitions(config)
+	exitOnError(err, "Unable to load container definitions")
+
+	p, err := containerdefs.BuildPlan(config, definitions, invalid)
+	exitOnError(err, "Unable to build plan")
+	printReport(p, format)
+
+	if p.HasChanges() {
+		os.Exit(2)
+	}
+}
+
+// status prints the current state and health of the container for each
+// container definition.
+func status(configFilePath, format string) {
+
+	validateFormat(format)
+	config := initialise(configFilePath)
+
+	// load container definitions
+	definitions, _, err := loadDefinitions(config)
+	exitOnError(err, "Unable to load container definitions")
+
+	s, err := containerdefs.BuildStatus(definitions)
+	exitOnError(err, "Unable to build status")
+	printReport(s, format)
+}
+
+// showHistory prints every revision recorded in oneill's history.
+func showHistory(configFilePath, format string) {
+
+	validateFormat(format)
+	config := initialise(configFilePath)
+
+	revisions, err := history.List(config)
+	exitOnError(err, "Unable to load history")
+	printReport(&history.History{Revisions: revisions}, format)
+}
+
+// rollback re-applies the definitions from a previous revision, with each
+// image pinned to the digest that was deployed at the time. The exit code
+// reflects the outcome of the run in the same way as apply.
+func rollback(configFilePath string, args []string, force bool) {
+
+	if len(args) != 1 {
+		exitOnError(fmt.Errorf("usage: oneill rollback <revision>"), "Unable to roll back")
+	}
+	id, err := strconv.Atoi(args[0])
+	exitOnError(err, "Unable to roll back")
+
+	l := lock()
+
+	config := initialise(configFilePath)
+	revision, err := history.Load(config, id)
+	exitOnError(err, "Unable to roll back")
+
+	// oneill or its configuration may have changed since the revision was
+	// recorded, so check its definitions are still valid before applying them
+	definitions := revision.PinnedDefinitions()
+	exitOnError(containerdefs.ValidateContainerDefinitions(config, definitions), "Unable to roll back")
+
+	logrus.WithFields(logrus.Fields{"revision": id}).Info("Rolling back to previous revision")
+
+	report := containerdefs.NewReport()
+	err = applyDefinitions(config, report, definitions, nil, fmt.Sprintf("rollback:%d", id), force)
+	report.Finish()
+	exitOnError(err, "Unable to roll back")
+	finishReport(config, report)
+
+	l.Close()
+	os.Exit(report.ExitCode())
+}
+
+func main() {
+
+	args := parseCliArgs()
+	if args.showVersion {
+		fmt.Printf("oneill v%s\n\n", version)
+		fmt.Printf("buildDate:     %s\n", buildDate)
+		fmt.Printf("gitBranch:     %s\n", gitBranch)
+		fmt.Printf("gitRevision:   %s\n", gitRevision)
+		fmt.Printf("gitRepoStatus: %s\n", gitRepoStatus)
+		os.Exit(0)
+	}
+
+	switch args.command {
+	case "apply":
+		apply(args.configFilePath, args.force)
+	case "daemon":
+		// a forced daemon would ignore the removal limits on every cycle,
+		// including against a source that's later truncated
+		if args.force {
+			exitOnError(fmt.Errorf("-force can't be used with the daemon command"), "Unable to run oneill")
+		}
+		daemon(args.configFilePath)
+	case "plan":
+		plan(args.configFilePath, args.format)
+	case "status":
+		status(args.configFilePath, args.format)
+	case "history":
+		showHistory(args.configFilePath, args.format)
+	case "rollback":
+		rollback(args.configFilePath, args.commandArgs, args.force)
+	default:
+		exitOnError(fmt.Errorf("unknown command: %s", args.command), "Unable to run oneill")
+	}
+
 }
@@ -0,0 +1,161 @@
+// Package schedule parses cron expressions used to run scheduled job
+// containers.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shortcuts supported in place of a full five field expression
+var shortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field describes the range of values permitted in each field of a cron
+// expression
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Schedule is a parsed cron expression. Each field is stored as a bitset of
+// the values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// cron matches a day if *either* the day of month or day of week fields
+	// match, unless one of them starts with `*` (e.g. `*` or `*/2`)
+	domAny, dowAny bool
+}
+
+// Parse parses a standard five field cron expression (minute, hour, day of
+// month, month, day of week) or one of the @hourly, @daily, @weekly,
+// @monthly or @yearly shortcuts. Each field supports `*`, single values,
+// ranges (`1-5`), steps (`*/15`, `0-30/10`, `5/15`) and comma separated
+// lists.
+func Parse(spec string) (*Schedule, error) {
+
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := shortcuts[spec]; ok {
+		spec = expanded
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("expected %d fields in cron expression, found %d: %s", len(fields), len(parts), spec)
+	}
+
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = b
+	}
+
+	// sunday can be written as either 0 or 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &Schedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(parts[2], "*"),
+		dowAny: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// parseField parses a single field of a cron expression into a bitset.
+func parseField(s string, f field) (uint64, error) {
+
+	var bits uint64
+	for _, item := range strings.Split(s, ",") {
+
+		// split off the step, if any
+		step, stepped := 1, false
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field: %s", f.name, item)
+			}
+			step, stepped = n, true
+			item = item[:i]
+		}
+
+		// work out the range of values covered by this item
+		var lo, hi int
+		switch {
+		case item == "*":
+			lo, hi = f.min, f.max
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			var err0, err1 error
+			lo, err0 = strconv.Atoi(bounds[0])
+			hi, err1 = strconv.Atoi(bounds[1])
+			if err0 != nil || err1 != nil {
+				return 0, fmt.Errorf("invalid range in %s field: %s", f.name, item)
+			}
+		default:
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %s field: %s", f.name, item)
+			}
+			lo, hi = n, n
+
+			// as in cron, a step from a single value runs to the end of the
+			// field's range (`5/15` is the same as `5-59/15`)
+			if stepped {
+				hi = f.max
+			}
+		}
+
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%s field out of range (%d-%d): %s", f.name, f.min, f.max, s)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// Matches reports whether the schedule fires during the minute containing t.
+func (s *Schedule) Matches(t time.Time) bool {
+
+	if s.minute&(1<<uint(t.Minute())) == 0 ||
+		s.hour&(1<<uint(t.Hour())) == 0 ||
+		s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,123 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@every 5m",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"1,,2 * * * *",
+	}
+
+	for _, spec := range specs {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected error parsing %q", spec)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+
+	// 2024-01-01 was a monday
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 30, 0, time.UTC)
+	}
+
+	tests := []struct {
+		spec    string
+		t       time.Time
+		matches bool
+	}{
+		// macros
+		{"@hourly", at(1, 1, 5, 0), true},
+		{"@hourly", at(1, 1, 5, 1), false},
+		{"@daily", at(1, 1, 0, 0), true},
+		{"@midnight", at(1, 1, 1, 0), false},
+		{"@weekly", at(1, 7, 0, 0), true},
+		{"@weekly", at(1, 8, 0, 0), false},
+		{"@monthly", at(2, 1, 0, 0), true},
+		{"@monthly", at(2, 2, 0, 0), false},
+		{"@yearly", at(1, 1, 0, 0), true},
+		{"@annually", at(2, 1, 0, 0), false},
+		{"  @daily  ", at(1, 1, 0, 0), true},
+
+		// single values and wildcards
+		{"* * * * *", at(6, 15, 13, 37), true},
+		{"30 4 * * *", at(1, 1, 4, 30), true},
+		{"30 4 * * *", at(1, 1, 4, 31), false},
+		{"30 4 * * *", at(1, 1, 5, 30), false},
+
+		// ranges
+		{"10-20 * * * *", at(1, 1, 0, 10), true},
+		{"10-20 * * * *", at(1, 1, 0, 20), true},
+		{"10-20 * * * *", at(1, 1, 0, 21), false},
+		{"* * * 3-5 *", at(4, 1, 0, 0), true},
+		{"* * * 3-5 *", at(6, 1, 0, 0), false},
+
+		// steps
+		{"*/15 * * * *", at(1, 1, 0, 45), true},
+		{"*/15 * * * *", at(1, 1, 0, 50), false},
+		{"0-30/10 * * * *", at(1, 1, 0, 30), true},
+		{"0-30/10 * * * *", at(1, 1, 0, 40), false},
+		{"5/15 * * * *", at(1, 1, 0, 5), true},
+		{"5/15 * * * *", at(1, 1, 0, 50), true},
+		{"5/15 * * * *", at(1, 1, 0, 0), false},
+
+		// lists
+		{"0,15,45 * * * *", at(1, 1, 0, 15), true},
+		{"0,15,45 * * * *", at(1, 1, 0, 30), false},
+		{"0 9-11,14 * * *", at(1, 1, 14, 0), true},
+		{"0 9-11,14 * * *", at(1, 1, 12, 0), false},
+
+		// sunday is either 0 or 7
+		{"0 0 * * 7", at(1, 7, 0, 0), true},
+		{"0 0 * * 0", at(1, 7, 0, 0), true},
+		{"0 0 * * 1-5", at(1, 6, 0, 0), false},
+
+		// restricting both day of month and day of week matches either
+		{"0 0 13 * 5", at(1, 13, 0, 0), true},
+		{"0 0 13 * 5", at(1, 5, 0, 0), true},
+		{"0 0 13 * 5", at(1, 6, 0, 0), false},
+
+		// unless one of them is a wildcard, in which case both must match
+		{"0 0 * * 5", at(1, 5, 0, 0), true},
+		{"0 0 * * 5", at(1, 6, 0, 0), false},
+		{"0 0 13 * *", at(1, 6, 0, 0), false},
+		{"0 0 */2 * 1", at(1, 3, 0, 0), false},
+		{"0 0 */2 * 1", at(1, 2, 0, 0), false},
+		{"0 0 */2 * 1", at(1, 15, 0, 0), true},
+		{"0 0 1 * */2", at(1, 1, 0, 0), false},
+		{"0 0 1 * */2", at(2, 1, 0, 0), true},
+		{"0 0 1 * */2", at(3, 1, 0, 0), false},
+		{"0 0 1 * */2", at(6, 1, 0, 0), true},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.spec, err)
+			continue
+		}
+		if s.Matches(test.t) != test.matches {
+			t.Errorf("Expected %q matching %s to be %t", test.spec, test.t.Format(time.RFC1123), test.matches)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rehabstudio/oneill/config"
+	"github.com/rehabstudio/oneill/containerdefs"
+)
+
+// maxCatchUp is the furthest back the scheduler looks for runs it missed,
+// e.g. while the host was suspended or after the clock jumped.
+const maxCatchUp = time.Hour
+
+// scheduler starts scheduled jobs at the start of each minute their schedule
+// matches. It runs in its own goroutine so that long reconcile cycles never
+// delay or skip a run, using the configuration and definitions from the last
+// cycle that loaded them successfully.
+type scheduler struct {
+	mu          sync.Mutex
+	config      *config.Configuration
+	definitions []*containerdefs.ContainerDefinition
+}
+
+// update replaces the configuration and definitions used for future runs.
+func (s *scheduler) update(config *config.Configuration, definitions []*containerdefs.ContainerDefinition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config, s.definitions = config, definitions
+}
+
+// current returns the configuration and definitions used for runs.
+func (s *scheduler) current() (*config.Configuration, []*containerdefs.ContainerDefinition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config, s.definitions
+}
+
+// run starts scheduled jobs until stop is closed. Every minute since the
+// last one processed is checked, so a minute is never skipped even if the
+// timer fires late, but each job is only run once (for the latest minute it
+// was due) however many of its runs were missed.
+func (s *scheduler) run(stop <-chan struct{}) {
+
+	last := time.Now().Truncate(time.Minute)
+	timer := time.NewTimer(nextMinute(time.Now()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-timer.C:
+			minute := now.Truncate(time.Minute)
+			s.runDue(last, minute)
+			last = minute
+			timer.Reset(nextMinute(time.Now()))
+		}
+	}
+}
+
+// runDue starts each scheduled job due in any minute after last, up to and
+// including minute. Jobs can run for a long time, each is run in the
+// background so it doesn't block other jobs.
+func (s *scheduler) runDue(last, minute time.Time) {
+
+	config, definitions := s.current()
+	if definitions == nil {
+		return
+	}
+
+	if earliest := minute.Add(-maxCatchUp); last.Before(earliest) {
+		last = earliest
+	}
+
+	due := make(map[string]time.Time)
+	var order []*containerdefs.ContainerDefinition
+	for t := last.Add(time.Minute); !t.After(minute); t = t.Add(time.Minute) {
+		for _, cd := range containerdefs.ScheduledDefinitionsDue(definitions, t) {
+			if _, ok := due[cd.ContainerName]; !ok {
+				order = append(order, cd)
+			}
+			due[cd.ContainerName] = t
+		}
+	}
+
+	for _, cd := range order {
+		t := due[cd.ContainerName]
+		if !t.Equal(minute) {
+			logrus.WithFields(logrus.Fields{
+				"container_name": cd.ContainerName,
+				"due":            t.Format(time.RFC3339),
+			}).Warning("Scheduled job missed its start time, running now")
+		}
+		go containerdefs.RunScheduledJob(config, cd, t)
+	}
+}